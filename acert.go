@@ -9,9 +9,10 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
 	"math/big"
 	"net"
-	"net/mail"
 	"net/url"
 	"strings"
 	"time"
@@ -23,10 +24,42 @@ type AcertOptions struct {
 	// Certificate
 	Days int
 
+	// Validity period
+	// Explicit dates take precedence over the validity duration,
+	// which takes precedence over the number of days.
+	NotBefore time.Time
+	NotAfter  time.Time
+	Validity  time.Duration
+
+	// Backdate moves the start of the validity period back to tolerate clock skew.
+	// It is not applied when NotBefore is set.
+	Backdate time.Duration
+
 	// Path length is used for certificate chaining
 	// https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.1.9
 	PathLenConstraint int
 
+	// Key usage
+	// Usages are inferred from the certificate when not set
+	KeyUsage            x509.KeyUsage
+	ExtKeyUsage         []x509.ExtKeyUsage
+	UnknownExtKeyUsage  []asn1.ObjectIdentifier
+	CriticalExtKeyUsage bool
+
+	// Authority information access and CRL distribution points
+	// https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.2.1
+	// https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.1.13
+	IssuingCertificateURL []string
+	OCSPServer            []string
+	CRLDistributionPoints []string
+
+	// Name constraints limiting the URIs an authority may issue certificates for
+	PermittedURIDomains []string
+
+	// Certificate policies and custom extensions
+	Policies   []CertificatePolicy
+	Extensions []pkix.Extension
+
 	// Private key
 	Algorithm string
 	Bits      int
@@ -42,13 +75,18 @@ type Acert struct {
 	RootPrivateKey          crypto.PrivateKey
 	RootCertificate         x509.Certificate
 	IntermediateCertificate x509.Certificate
+	Intermediates           []x509.Certificate
 	Subject                 pkix.Name
 
+	// DER-encoded subject, used instead of Subject to preserve the order of attributes
+	RawSubject []byte
+
 	// Outputs
-	PrivateKey  crypto.PrivateKey
-	PublicKey   crypto.PublicKey
-	Certificate x509.Certificate
-	Request     x509.CertificateRequest
+	PrivateKey     crypto.PrivateKey
+	PublicKey      crypto.PublicKey
+	Certificate    x509.Certificate
+	Request        x509.CertificateRequest
+	VerifiedChains [][]*x509.Certificate
 }
 
 // BuildCertificate builds a PKI certificate
@@ -60,6 +98,7 @@ func (a *Acert) BuildCertificate(isCa bool) []byte {
 	} else {
 		// Parse configured hosts
 		a.Certificate.Subject = a.Subject
+		a.Certificate.RawSubject = a.RawSubject
 		a.ParseSubjectAlternativeNames()
 
 		// Require private key for request
@@ -69,39 +108,34 @@ func (a *Acert) BuildCertificate(isCa bool) []byte {
 
 	// Try to set a common name if one is not set
 	if a.Subject.CommonName == "" && len(a.Hosts) > 0 {
-		a.Subject.CommonName = a.Hosts[0]
+		a.Subject.CommonName = subjectAlternativeNameValue(a.Hosts[0])
 	}
 
 	// Other certificate properties
 	a.GenerateSerialNumber()
-	a.Certificate.NotBefore = now
 	a.Certificate.IsCA = isCa
-
-	// Add expiration date based on the configured number of days
-	if a.Options.Days > 0 {
-		if a.Options.Days > 825 {
-			log("Warning: iOS and macOS certificates must have a validity period of 825 days or fewer")
-			log("Reference: https://support.apple.com/en-us/HT210176")
-		}
-		a.Certificate.NotAfter = now.Add(time.Hour * 24 * time.Duration(a.Options.Days))
-	}
+	a.SetValidity()
 
 	// Key usage
 	if isCa {
 		a.Certificate.BasicConstraintsValid = true
-		a.Certificate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
-	} else {
-		a.Certificate.KeyUsage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
-		if len(a.Certificate.IPAddresses) > 0 || len(a.Certificate.DNSNames) > 0 || len(a.Certificate.URIs) > 0 {
-			a.Certificate.ExtKeyUsage = append(a.Certificate.ExtKeyUsage, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth)
-		}
+	}
+	a.SetKeyUsage(isCa)
 
-		// Email protection
-		if len(a.Certificate.EmailAddresses) > 0 {
-			a.Certificate.ExtKeyUsage = append(a.Certificate.ExtKeyUsage, x509.ExtKeyUsageEmailProtection)
-		}
+	// Locations of the issuer certificate and revocation status
+	a.Certificate.IssuingCertificateURL = a.Options.IssuingCertificateURL
+	a.Certificate.OCSPServer = a.Options.OCSPServer
+	a.Certificate.CRLDistributionPoints = a.Options.CRLDistributionPoints
+
+	// Name constraints
+	if len(a.Options.PermittedURIDomains) > 0 {
+		a.Certificate.PermittedURIDomains = a.Options.PermittedURIDomains
+		a.Certificate.PermittedDNSDomainsCritical = true
 	}
 
+	// Certificate policies and custom extensions
+	a.SetExtensions()
+
 	// Path length for certificate chaining
 	if a.Options.PathLenConstraint > 0 {
 		a.Certificate.MaxPathLen = a.Options.PathLenConstraint
@@ -126,6 +160,77 @@ func (a *Acert) BuildCertificate(isCa bool) []byte {
 	return certificateBytes
 }
 
+// validityPeriod returns the certificate validity period from the configured options.
+// Leaf certificates never outlive the issuer, so the end of the
+// validity period is clamped to the issuer's expiration date.
+func (a *Acert) validityPeriod() (start time.Time, end time.Time, clamped bool) {
+	issued := time.Now()
+
+	start = a.Options.NotBefore
+	if start.IsZero() {
+		start = issued.Add(-a.Options.Backdate)
+	} else {
+		issued = start
+	}
+
+	end = a.Options.NotAfter
+	if end.IsZero() {
+		switch {
+		case a.Options.Validity > 0:
+			end = issued.Add(a.Options.Validity)
+		case a.Options.Days > 0:
+			end = issued.Add(time.Hour * 24 * time.Duration(a.Options.Days))
+		}
+	}
+
+	// Clamp to the issuer validity period
+	isSigned := a.RootCertificate.SerialNumber != nil && a.RootPrivateKey != nil
+	if isSigned && !end.IsZero() && end.After(a.RootCertificate.NotAfter) {
+		end, clamped = a.RootCertificate.NotAfter, true
+	}
+
+	return start, end, clamped
+}
+
+// CheckValidity returns an error when the validity period is empty,
+// for example when the issuer expires before the certificate would start.
+func (a *Acert) CheckValidity() error {
+	start, end, clamped := a.validityPeriod()
+	if end.IsZero() || end.After(start) {
+		return nil
+	}
+
+	switch {
+	case clamped && a.RootCertificate.NotAfter.Before(time.Now()):
+		return fmt.Errorf("the issuer certificate '%s' expired on %s", a.RootCertificate.Subject, end.Format(time.RFC3339))
+	case clamped:
+		return fmt.Errorf("the certificate start date %s is not before the issuer expiration date %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	default:
+		return fmt.Errorf("the certificate expiration date %s is not after its start date %s", end.Format(time.RFC3339), start.Format(time.RFC3339))
+	}
+}
+
+// SetValidity sets the certificate validity period from the configured options.
+// Callers are expected to check the validity period with CheckValidity first.
+func (a *Acert) SetValidity() {
+	if err := a.CheckValidity(); err != nil {
+		panic(err)
+	}
+
+	start, end, clamped := a.validityPeriod()
+	if clamped {
+		log("Warning: validity period clamped to the issuer expiration date:", end.Format(time.RFC3339))
+	}
+
+	if end.Sub(start) > time.Hour*24*825 {
+		log("Warning: iOS and macOS certificates must have a validity period of 825 days or fewer")
+		log("Reference: https://support.apple.com/en-us/HT210176")
+	}
+
+	a.Certificate.NotBefore = start
+	a.Certificate.NotAfter = end
+}
+
 // BuildCertificateRequest generates a certificate signing request
 func (a *Acert) BuildCertificateRequest() []byte {
 	// Require private key for request
@@ -134,10 +239,12 @@ func (a *Acert) BuildCertificateRequest() []byte {
 	// Build request template
 	a.ParseSubjectAlternativeNames()
 	a.Request.Subject = a.Subject
+	a.Request.RawSubject = a.RawSubject
 	a.Request.DNSNames = a.Certificate.DNSNames
 	a.Request.IPAddresses = a.Certificate.IPAddresses
 	a.Request.EmailAddresses = a.Certificate.EmailAddresses
 	a.Request.URIs = a.Certificate.URIs
+	a.Request.ExtraExtensions = a.Certificate.ExtraExtensions
 
 	// Build certificate signing request
 	csr, err := x509.CreateCertificateRequest(rand.Reader, &a.Request, a.PrivateKey)
@@ -153,9 +260,17 @@ func (a *Acert) BuildCertificateRequest() []byte {
 // with values from a certificate signing request.
 func (a *Acert) DecorateCertificateFromRequest() {
 	a.Certificate.Subject = a.Request.Subject
+	a.Certificate.RawSubject = a.Request.RawSubject
 	a.Certificate.Extensions = a.Request.Extensions
 	a.Certificate.ExtraExtensions = a.Request.ExtraExtensions
 
+	// Keep subject alternative names that x509 templates do not support
+	for _, extension := range a.Request.Extensions {
+		if extension.Id.Equal(oidExtensionSubjectAltName) && hasCustomSubjectAlternativeNames(extension) {
+			a.Certificate.ExtraExtensions = append(a.Certificate.ExtraExtensions, extension)
+		}
+	}
+
 	// SAN
 	for _, value := range a.Request.DNSNames {
 		a.Hosts = append(a.Hosts, value)
@@ -244,18 +359,39 @@ func (a *Acert) GenerateSerialNumber() {
 
 // ParseSanHosts takes a string array and
 // returns a new x509.Certificate populated with parsed
-// subject alternative name values
+// subject alternative name values.
+// Names that x509 templates do not support (user principal names and
+// registered IDs) are encoded in a custom subject alternative name extension.
 func (a *Acert) ParseSubjectAlternativeNames() {
+	custom := false
+
 	for _, value := range a.Hosts {
-		if ip := net.ParseIP(value); ip != nil {
-			a.Certificate.IPAddresses = append(a.Certificate.IPAddresses, ip)
-		} else if email, err := mail.ParseAddress(value); err == nil && email.Address == value {
-			a.Certificate.EmailAddresses = append(a.Certificate.EmailAddresses, email.Address)
-		} else if uri, err := url.Parse(value); err == nil && uri.Scheme != "" && uri.Host != "" {
+		kind, name, err := ParseSubjectAlternativeName(value)
+		if err != nil {
+			panic(err)
+		}
+
+		switch kind {
+		case "ip":
+			a.Certificate.IPAddresses = append(a.Certificate.IPAddresses, net.ParseIP(name))
+		case "email":
+			a.Certificate.EmailAddresses = append(a.Certificate.EmailAddresses, name)
+		case "uri":
+			uri, _ := url.Parse(name)
 			a.Certificate.URIs = append(a.Certificate.URIs, uri)
-		} else {
-			a.Certificate.DNSNames = append(a.Certificate.DNSNames, value)
+		case "dns":
+			a.Certificate.DNSNames = append(a.Certificate.DNSNames, name)
+		default:
+			custom = true
+		}
+	}
+
+	if custom {
+		extension, err := subjectAlternativeNameExtension(a.Hosts, len(a.Subject.ToRDNSequence()) == 0)
+		if err != nil {
+			panic(err)
 		}
+		a.Certificate.ExtraExtensions = append(a.Certificate.ExtraExtensions, extension)
 	}
 }
 
@@ -279,20 +415,23 @@ func (a *Acert) Verify() error {
 		options.Roots = rootPool
 	}
 
-	// Add intermediate certificate
+	// Add intermediate certificates
+	intermediatePool := x509.NewCertPool()
 	if a.IntermediateCertificate.SerialNumber != nil {
-		intermediatePool := x509.NewCertPool()
 		intermediatePool.AddCert(&a.IntermediateCertificate)
-		options.Intermediates = intermediatePool
 	}
+	for i := range a.Intermediates {
+		intermediatePool.AddCert(&a.Intermediates[i])
+	}
+	options.Intermediates = intermediatePool
 
 	if len(a.Hosts) > 0 {
 		for _, host := range a.Hosts {
 			options.DNSName = host
-			_, err = a.Certificate.Verify(options)
+			a.VerifiedChains, err = a.Certificate.Verify(options)
 		}
 	} else {
-		_, err = a.Certificate.Verify(options)
+		a.VerifiedChains, err = a.Certificate.Verify(options)
 	}
 
 	return err
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAuthority creates a certificate authority and saves its
+// certificate and private key PEM files to a directory.
+func testAuthority(t *testing.T, dir string, name string) (*Acert, string, string) {
+	t.Helper()
+
+	a := &Acert{
+		Subject: pkix.Name{CommonName: name},
+		Options: AcertOptions{Days: 365, Algorithm: "ecdsa-p256"},
+	}
+	bytes := a.BuildCertificate(true)
+
+	cert := filepath.Join(dir, name+".ca.cert.pem")
+	key := filepath.Join(dir, name+".ca.key.pem")
+	if err := writeFileAtomic(cert, pemEncode("CERTIFICATE", bytes), 0644, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(key, pemEncode("PRIVATE KEY", privateKeyPkcs(a.PrivateKey)), 0600, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	return a, cert, key
+}
+
+// testCertificate parses a PEM-encoded certificate file.
+func testCertificate(t *testing.T, file string) *x509.Certificate {
+	t.Helper()
+
+	certs, err := readPemCertificates(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certs[0]
+}
+
+// testIssue issues a certificate for hosts signed by an authority
+// and saves its certificate and private key PEM files to a directory.
+func testIssue(t *testing.T, dir string, authorityCert string, authorityKey string, hosts ...string) (string, string) {
+	t.Helper()
+
+	a := &Acert{
+		Hosts:           hosts,
+		RootCertificate: *parsePemCertificate(authorityCert),
+		RootPrivateKey:  parsePemPrivateKey(authorityKey),
+		Options:         AcertOptions{Days: 30, Algorithm: "ecdsa-p256"},
+	}
+	bytes := a.BuildCertificate(false)
+
+	cert := filepath.Join(dir, hosts[0]+".cert.pem")
+	key := filepath.Join(dir, hosts[0]+".key.pem")
+	if err := writeFileAtomic(cert, pemEncode("CERTIFICATE", bytes), 0644, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(key, pemEncode("PRIVATE KEY", privateKeyPkcs(a.PrivateKey)), 0600, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestCheckValidity(t *testing.T) {
+	now := time.Now()
+	issuer := x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "issuer"}, NotAfter: now.Add(24 * time.Hour)}
+	expired := x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "expired"}, NotAfter: now.Add(-24 * time.Hour)}
+
+	tests := []struct {
+		name    string
+		issuer  *x509.Certificate
+		options AcertOptions
+		err     string
+	}{
+		{"days", nil, AcertOptions{Days: 90}, ""},
+		{"clamped to issuer", &issuer, AcertOptions{Days: 90}, ""},
+		{"explicit dates", nil, AcertOptions{NotBefore: now, NotAfter: now.Add(time.Hour)}, ""},
+		{"end before start", nil, AcertOptions{NotBefore: now, NotAfter: now.Add(-time.Hour)}, "is not after its start date"},
+		{"start after issuer", &issuer, AcertOptions{NotBefore: now.Add(48 * time.Hour), Days: 1}, "is not before the issuer expiration date"},
+		{"expired issuer", &expired, AcertOptions{Days: 90}, "expired on"},
+		{"self-signed is not clamped", nil, AcertOptions{NotBefore: now.Add(48 * time.Hour), Days: 1}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Acert{Options: tt.options}
+			if tt.issuer != nil {
+				a.RootCertificate = *tt.issuer
+				a.RootPrivateKey = struct{}{}
+			}
+
+			err := a.CheckValidity()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("CheckValidity() = %v, want nil", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("CheckValidity() = %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
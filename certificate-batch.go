@@ -0,0 +1,667 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/lstellway/go/command"
+	"gopkg.in/yaml.v3"
+)
+
+// BatchIssuer references the certificate and private key
+// used to sign certificates in a batch manifest.
+type BatchIssuer struct {
+	Certificate string `json:"certificate" yaml:"certificate"`
+	Key         string `json:"key" yaml:"key"`
+}
+
+// BatchFiles overrides the paths certificate files are saved to.
+// Relative paths are resolved against the entry output directory.
+type BatchFiles struct {
+	Certificate string `json:"certificate" yaml:"certificate"`
+	Key         string `json:"key" yaml:"key"`
+	Chain       string `json:"chain" yaml:"chain"`
+	Fullchain   string `json:"fullchain" yaml:"fullchain"`
+}
+
+// BatchCertificate describes a single certificate in a batch manifest.
+// Empty values fall back to the manifest defaults.
+type BatchCertificate struct {
+	Name        string        `json:"name" yaml:"name"`
+	Profile     string        `json:"profile" yaml:"profile"`
+	Subject     SubjectFields `json:"subject" yaml:"subject"`
+	San         []string      `json:"san" yaml:"san"`
+	Algorithm   string        `json:"algorithm" yaml:"algorithm"`
+	Bits        int           `json:"bits" yaml:"bits"`
+	Days        int           `json:"days" yaml:"days"`
+	Validity    string        `json:"validity" yaml:"validity"`
+	KeyUsage    []string      `json:"keyUsage" yaml:"keyUsage"`
+	ExtKeyUsage []string      `json:"extKeyUsage" yaml:"extKeyUsage"`
+	IssuerURL   []string      `json:"issuerURL" yaml:"issuerURL"`
+	OcspURL     []string      `json:"ocspURL" yaml:"ocspURL"`
+	CrlURL      []string      `json:"crlURL" yaml:"crlURL"`
+	Policies    []string      `json:"policies" yaml:"policies"`
+	Extensions  []string      `json:"extensions" yaml:"extensions"`
+	PathLen     int           `json:"pathLength" yaml:"pathLength"`
+	Output      string        `json:"output" yaml:"output"`
+	Files       BatchFiles    `json:"files" yaml:"files"`
+	Issuer      *BatchIssuer  `json:"issuer" yaml:"issuer"`
+}
+
+// BatchProfile defines a certificate profile in a batch manifest.
+// Profiles extend a built-in profile and are selected by name like built-in profiles.
+type BatchProfile struct {
+	Extends    string   `json:"extends" yaml:"extends"`
+	IssuerURL  []string `json:"issuerURL" yaml:"issuerURL"`
+	OcspURL    []string `json:"ocspURL" yaml:"ocspURL"`
+	CrlURL     []string `json:"crlURL" yaml:"crlURL"`
+	Policies   []string `json:"policies" yaml:"policies"`
+	Extensions []string `json:"extensions" yaml:"extensions"`
+}
+
+// BatchManifest describes a set of certificates to issue at once.
+type BatchManifest struct {
+	Issuer       *BatchIssuer            `json:"issuer" yaml:"issuer"`
+	Profiles     map[string]BatchProfile `json:"profiles" yaml:"profiles"`
+	Defaults     BatchCertificate        `json:"defaults" yaml:"defaults"`
+	Workers      int                     `json:"workers" yaml:"workers"`
+	Certificates []BatchCertificate      `json:"certificates" yaml:"certificates"`
+}
+
+// batchProfiles maps names to the profiles defined in a batch manifest.
+type batchProfiles map[string]CertificateProfile
+
+// batchSigner holds a parsed issuer certificate and key.
+type batchSigner struct {
+	certificate x509.Certificate
+	key         crypto.PrivateKey
+	chain       []byte
+}
+
+// batchFile is a file written for a batch certificate.
+type batchFile struct {
+	path        string
+	data        []byte
+	permissions os.FileMode
+	uid, gid    int
+}
+
+// batchResult reports the outcome of issuing a single certificate.
+type batchResult struct {
+	name  string
+	files []string
+	err   error
+}
+
+// parseBatchManifest reads a YAML or JSON batch manifest.
+// JSON is used for files with a ".json" extension.
+func parseBatchManifest(file string) BatchManifest {
+	var (
+		manifest BatchManifest
+		err      error
+		data     = readFile(file)
+	)
+
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		err = json.Unmarshal(data, &manifest)
+	} else {
+		err = yaml.Unmarshal(data, &manifest)
+	}
+	exitOnError(err, "Could not parse manifest:", file, err)
+
+	if len(manifest.Certificates) == 0 {
+		exit(1, "Manifest does not contain any certificates:", file)
+	}
+
+	return manifest
+}
+
+// certificateProfile builds the certificate profile defined by a manifest profile.
+func (p BatchProfile) certificateProfile() (CertificateProfile, error) {
+	profile, err := lookupProfile(p.Extends)
+	if err != nil {
+		return profile, err
+	}
+
+	for _, urls := range [][]string{p.IssuerURL, p.OcspURL, p.CrlURL} {
+		if _, err = parseURLs(urls); err != nil {
+			return profile, err
+		}
+	}
+	if len(p.IssuerURL) > 0 {
+		profile.IssuingCertificateURL = p.IssuerURL
+	}
+	if len(p.OcspURL) > 0 {
+		profile.OCSPServer = p.OcspURL
+	}
+	if len(p.CrlURL) > 0 {
+		profile.CRLDistributionPoints = p.CrlURL
+	}
+
+	if len(p.Policies) > 0 {
+		if profile.Policies, err = ParseCertificatePolicies(p.Policies); err != nil {
+			return profile, err
+		}
+	}
+	if len(p.Extensions) > 0 {
+		if profile.Extensions, err = ParseExtensions(p.Extensions); err != nil {
+			return profile, err
+		}
+	}
+
+	return profile, nil
+}
+
+// parseBatchProfiles builds the profiles defined in a manifest.
+// Manifest profiles cannot replace built-in profiles.
+func parseBatchProfiles(profiles map[string]BatchProfile) (batchProfiles, error) {
+	parsed := batchProfiles{}
+
+	for name, p := range profiles {
+		key := strings.ToLower(strings.TrimSpace(name))
+		if _, ok := certificateProfiles[key]; ok {
+			return nil, fmt.Errorf("profiles.%s: a built-in profile has the same name", name)
+		}
+		if _, ok := parsed[key]; ok {
+			return nil, fmt.Errorf("profiles.%s: profile is defined more than once", name)
+		}
+
+		profile, err := p.certificateProfile()
+		if err != nil {
+			return nil, fmt.Errorf("profiles.%s: %v", name, err)
+		}
+		parsed[key] = profile
+	}
+
+	return parsed, nil
+}
+
+// lookup returns the manifest or built-in profile with the given name
+func (p batchProfiles) lookup(name string) (CertificateProfile, error) {
+	if profile, ok := p[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return profile, nil
+	}
+	return lookupProfile(name)
+}
+
+// applyDefaults fills empty entry values with manifest defaults.
+func (e BatchCertificate) applyDefaults(d BatchCertificate) BatchCertificate {
+	if e.Profile == "" {
+		e.Profile = d.Profile
+	}
+	e.Subject = e.Subject.applyDefaults(d.Subject, len(e.San) == 0)
+	if len(e.San) == 0 {
+		e.San = d.San
+	}
+	if e.Algorithm == "" {
+		e.Algorithm = d.Algorithm
+	}
+	if e.Bits == 0 {
+		e.Bits = d.Bits
+	}
+	if e.Days == 0 {
+		e.Days = d.Days
+	}
+	if e.Validity == "" {
+		e.Validity = d.Validity
+	}
+	if len(e.KeyUsage) == 0 {
+		e.KeyUsage = d.KeyUsage
+	}
+	if len(e.ExtKeyUsage) == 0 {
+		e.ExtKeyUsage = d.ExtKeyUsage
+	}
+	if len(e.IssuerURL) == 0 {
+		e.IssuerURL = d.IssuerURL
+	}
+	if len(e.OcspURL) == 0 {
+		e.OcspURL = d.OcspURL
+	}
+	if len(e.CrlURL) == 0 {
+		e.CrlURL = d.CrlURL
+	}
+	if len(e.Policies) == 0 {
+		e.Policies = d.Policies
+	}
+	if len(e.Extensions) == 0 {
+		e.Extensions = d.Extensions
+	}
+	if e.PathLen == 0 {
+		e.PathLen = d.PathLen
+	}
+	if e.Output == "" {
+		e.Output = d.Output
+	}
+	if e.Issuer == nil {
+		e.Issuer = d.Issuer
+	}
+
+	return e
+}
+
+// applyDefaults fills empty subject fields with default values.
+// A distinguished name replaces the other fields, so it is only inherited by empty subjects,
+// and the common name is only inherited by entries without their own subject alternative names.
+func (f SubjectFields) applyDefaults(d SubjectFields, inheritCommonName bool) SubjectFields {
+	if f.DN != "" {
+		return f
+	}
+	if f == (SubjectFields{}) && d.DN != "" {
+		return SubjectFields{DN: d.DN}
+	}
+
+	f.Country = defaultValue(f.Country, d.Country)
+	f.Province = defaultValue(f.Province, d.Province)
+	f.Locality = defaultValue(f.Locality, d.Locality)
+	f.StreetAddress = defaultValue(f.StreetAddress, d.StreetAddress)
+	f.PostalCode = defaultValue(f.PostalCode, d.PostalCode)
+	f.Organization = defaultValue(f.Organization, d.Organization)
+	f.OrganizationalUnit = defaultValue(f.OrganizationalUnit, d.OrganizationalUnit)
+	f.Email = defaultValue(f.Email, d.Email)
+	if inheritCommonName {
+		f.CommonName = defaultValue(f.CommonName, d.CommonName)
+	}
+
+	return f
+}
+
+// defaultValue returns the fallback when a value is empty.
+func defaultValue(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// resolvePath resolves a path relative to a base directory.
+func resolvePath(base string, file string) string {
+	if file == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(base, file)
+}
+
+// filePaths returns the resolved paths an entry is saved to.
+// Files are named after the entry name, the common name or the first subject alternative name.
+// Entries without a name return no paths and fail when they are issued.
+func (e BatchCertificate) filePaths(profiles batchProfiles) []string {
+	name := defaultValue(e.Name, e.Subject.CommonName)
+	if e.Subject.DN != "" {
+		var dn Acert
+		if sequence, err := ParseDistinguishedName(e.Subject.DN); err == nil {
+			dn.SetSubjectSequence(sequence)
+		}
+		name = defaultValue(e.Name, dn.Subject.CommonName)
+	}
+	if name == "" && len(e.San) > 0 {
+		name = subjectAlternativeNameValue(e.San[0])
+	}
+	if name == "" {
+		return nil
+	}
+
+	if profile, err := profiles.lookup(e.Profile); err == nil && profile.Authority {
+		name = name + ".ca"
+	}
+	paths := []string{
+		resolvePath(e.Output, defaultValue(e.Files.Certificate, name+".cert.pem")),
+		resolvePath(e.Output, defaultValue(e.Files.Key, name+".key.pem")),
+	}
+	if e.Issuer != nil {
+		paths = append(paths,
+			resolvePath(e.Output, defaultValue(e.Files.Chain, name+".chain.pem")),
+			resolvePath(e.Output, defaultValue(e.Files.Fullchain, name+".fullchain.pem")),
+		)
+	}
+	return paths
+}
+
+// validateBatchEntries checks entries before any certificate is issued,
+// so an invalid manifest does not leave some certificates behind.
+func validateBatchEntries(entries []BatchCertificate, profiles batchProfiles) error {
+	saved := map[string]int{}
+
+	for i, e := range entries {
+		if _, err := parseValidity(e.Validity); err != nil {
+			return fmt.Errorf("certificates[%d]: invalid validity '%s': %v", i, e.Validity, err)
+		}
+		for _, path := range e.filePaths(profiles) {
+			if j, ok := saved[path]; ok {
+				return fmt.Errorf("certificates[%d] and certificates[%d] are both saved to %s", j, i, path)
+			}
+			saved[path] = i
+		}
+	}
+
+	return nil
+}
+
+// loadBatchSigner parses an issuer certificate and key.
+func loadBatchSigner(issuer BatchIssuer) *batchSigner {
+	requireFileValue(&issuer.Certificate, "issuer.certificate")
+	requireFileValue(&issuer.Key, "issuer.key")
+	warnInsecureKeyPermissions(issuer.Key)
+
+	signer := &batchSigner{
+		certificate: *parsePemCertificate(issuer.Certificate),
+		key:         parsePemPrivateKey(issuer.Key),
+		chain:       readFile(issuer.Certificate),
+	}
+	requireMatchingKey(&signer.certificate, signer.key, issuer.Key, issuer.Certificate)
+
+	return signer
+}
+
+// issueBatchCertificate builds and saves a single certificate from a manifest entry.
+// Acert methods panic on failure, so panics are recovered and reported as errors
+// to allow the remaining certificates in the batch to be issued.
+func issueBatchCertificate(entry BatchCertificate, profiles batchProfiles, signer *batchSigner, keyFile batchFile) (result batchResult) {
+	result.name = entry.Name
+	defer func() {
+		if r := recover(); r != nil {
+			result.err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	// A full distinguished name replaces the other subject fields
+	var dn Acert
+	if entry.Subject.DN != "" {
+		if (entry.Subject != SubjectFields{DN: entry.Subject.DN}) {
+			result.err = fmt.Errorf("a subject 'dn' cannot be combined with other subject fields")
+			return
+		}
+		sequence, err := ParseDistinguishedName(entry.Subject.DN)
+		if err != nil {
+			result.err = err
+			return
+		}
+		dn.SetSubjectSequence(sequence)
+		entry.Subject.CommonName = dn.Subject.CommonName
+	}
+
+	// Default the common name to the first subject alternative name
+	if entry.Subject.CommonName == "" && len(entry.San) > 0 {
+		entry.Subject.CommonName = subjectAlternativeNameValue(entry.San[0])
+	}
+	if entry.Subject.CommonName == "" {
+		result.err = fmt.Errorf("a common name or subject alternative name is required")
+		return
+	}
+	result.name = defaultValue(result.name, entry.Subject.CommonName)
+
+	if err := parseSubjectAlternativeNameValues(entry.San); err != nil {
+		result.err = err
+		return
+	}
+
+	profile, err := profiles.lookup(entry.Profile)
+	if err != nil {
+		result.err = err
+		return
+	}
+	isCa := profile.Authority
+
+	validity, err := parseValidity(entry.Validity)
+	if err != nil {
+		result.err = err
+		return
+	}
+
+	usage, err := ParseKeyUsage(entry.KeyUsage)
+	if err != nil {
+		result.err = err
+		return
+	}
+	extUsage, unknownExtUsage, err := ParseExtKeyUsage(entry.ExtKeyUsage)
+	if err != nil {
+		result.err = err
+		return
+	}
+
+	for _, urls := range [][]string{entry.IssuerURL, entry.OcspURL, entry.CrlURL} {
+		if _, err = parseURLs(urls); err != nil {
+			result.err = err
+			return
+		}
+	}
+
+	policies, err := ParseCertificatePolicies(entry.Policies)
+	if err != nil {
+		result.err = err
+		return
+	}
+	extensions, err := ParseExtensions(entry.Extensions)
+	if err != nil {
+		result.err = err
+		return
+	}
+
+	if entry.Subject.DN == "" {
+		dn.Subject = entry.Subject.Name()
+	}
+
+	a := Acert{
+		Hosts:      entry.San,
+		Subject:    dn.Subject,
+		RawSubject: dn.RawSubject,
+		Options: AcertOptions{
+			Algorithm:          entry.Algorithm,
+			Bits:               entry.Bits,
+			Days:               entry.Days,
+			Validity:           validity,
+			Backdate:           defaultBackdate,
+			KeyUsage:           usage,
+			ExtKeyUsage:        extUsage,
+			UnknownExtKeyUsage: unknownExtUsage,
+			PathLenConstraint:  entry.PathLen,
+
+			IssuingCertificateURL: entry.IssuerURL,
+			OCSPServer:            entry.OcspURL,
+			CRLDistributionPoints: entry.CrlURL,
+
+			Policies:   policies,
+			Extensions: extensions,
+		},
+	}
+	profile.Apply(&a.Options)
+	if err = a.CheckExtensions(); err != nil {
+		result.err = err
+		return
+	}
+	if signer != nil {
+		a.RootCertificate = signer.certificate
+		a.RootPrivateKey = signer.key
+	}
+	if err = a.CheckValidity(); err != nil {
+		result.err = err
+		return
+	}
+
+	certificatePem := pemEncode("CERTIFICATE", a.BuildCertificate(isCa))
+	keyPem := pemEncode("PRIVATE KEY", privateKeyPkcs(a.PrivateKey))
+
+	// Build files
+	paths := entry.filePaths(profiles)
+	files := []batchFile{
+		{paths[0], certificatePem, 0644, -1, -1},
+		{paths[1], keyPem, keyFile.permissions, keyFile.uid, keyFile.gid},
+	}
+	if signer != nil {
+		fullchainPem := append(append([]byte{}, certificatePem...), signer.chain...)
+		files = append(files,
+			batchFile{paths[2], signer.chain, 0644, -1, -1},
+			batchFile{paths[3], fullchainPem, 0644, -1, -1},
+		)
+	}
+
+	// Save files
+	for _, f := range files {
+		name := f.path
+		if err = os.MkdirAll(filepath.Dir(name), 0755); err == nil {
+			err = writeFileAtomic(name, f.data, f.permissions, f.uid, f.gid)
+		}
+		if err != nil {
+			result.err = err
+			return
+		}
+		result.files = append(result.files, name)
+	}
+
+	return
+}
+
+// issueBatch issues every certificate in a manifest using a pool of workers.
+// Each issuer is loaded once and shared by all certificates it signs.
+func issueBatch(manifest BatchManifest, base string, workers int) []batchResult {
+	signers := map[BatchIssuer]*batchSigner{}
+	entries := make([]BatchCertificate, len(manifest.Certificates))
+
+	if manifest.Defaults.Issuer == nil {
+		manifest.Defaults.Issuer = manifest.Issuer
+	}
+	if manifest.Defaults.Output == "" {
+		manifest.Defaults.Output = outputDirectory
+	}
+
+	for i, entry := range manifest.Certificates {
+		entry = entry.applyDefaults(manifest.Defaults)
+		entry.Output = resolvePath(base, entry.Output)
+
+		if entry.Issuer != nil {
+			issuer := BatchIssuer{
+				Certificate: resolvePath(base, entry.Issuer.Certificate),
+				Key:         resolvePath(base, entry.Issuer.Key),
+			}
+			if _, ok := signers[issuer]; !ok {
+				signers[issuer] = loadBatchSigner(issuer)
+			}
+			entry.Issuer = &issuer
+		}
+
+		entries[i] = entry
+	}
+
+	profiles, err := parseBatchProfiles(manifest.Profiles)
+	exitOnError(err, "Invalid manifest:", err)
+
+	err = validateBatchEntries(entries, profiles)
+	exitOnError(err, "Invalid manifest:", err)
+
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	var (
+		wg      sync.WaitGroup
+		jobs    = make(chan int)
+		results = make([]batchResult, len(entries))
+
+		// Private key file mode and ownership are shared by all entries
+		keyFile = batchFile{
+			permissions: parseFileMode(keyMode, "keyMode"),
+			uid:         lookupUserId(keyOwner),
+			gid:         lookupGroupId(keyGroup),
+		}
+	)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var signer *batchSigner
+				if entries[i].Issuer != nil {
+					signer = signers[*entries[i].Issuer]
+				}
+				results[i] = issueBatchCertificate(entries[i], profiles, signer, keyFile)
+				if results[i].name == "" {
+					results[i].name = fmt.Sprintf("certificates[%d]", i)
+				}
+			}
+		}()
+	}
+
+	for i := range entries {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// batchCertificates handles command-line input arguments
+// to issue certificates described in a manifest file.
+func batchCertificates(flags ...string) {
+	var workers int
+
+	// Initialize command
+	cmd, args = newCommand(commandName("batch"), "Issue certificates described in a manifest file", func(h *command.Command) {
+		h.AddSection("General Options", func(s *command.CommandSection) {
+			generalFlags(s)
+			s.IntVar(&workers, "workers", 0, "Number of certificates to issue concurrently (defaults to the manifest value or number of CPUs)")
+		})
+
+		h.AddArgument("MANIFEST")
+
+		h.AddExample("Issue certificates from a YAML manifest", "certificates.yaml")
+		h.AddExample("Issue certificates using 8 workers", "-workers 8 certificates.json")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	arg := getArgument(true)
+
+	switch arg {
+	case "", "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&arg, "MANIFEST")
+		requireFileValue(&outputDirectory, "output")
+
+		// The output directory is relative to the working directory, not the manifest
+		output, err := filepath.Abs(outputDirectory)
+		exitOnError(err, "Invalid value for 'output' argument:", err)
+		outputDirectory = output
+
+		manifest := parseBatchManifest(arg)
+		if workers == 0 {
+			workers = manifest.Workers
+		}
+		if manifest.Defaults.Bits == 0 {
+			manifest.Defaults.Bits = 2048
+		}
+		if manifest.Defaults.Days == 0 {
+			manifest.Defaults.Days = 90
+		}
+
+		results := issueBatch(manifest, filepath.Dir(arg), workers)
+
+		// Summary
+		failed := 0
+		for _, r := range results {
+			if r.err != nil {
+				failed++
+				log(fmt.Sprintf("✗ %s: %v", r.name, r.err))
+				continue
+			}
+
+			log(fmt.Sprintf("✓ %s", r.name))
+			for _, file := range r.files {
+				log("    Saved file:", file)
+			}
+		}
+
+		log(fmt.Sprintf("\n%d certificate(s) issued, %d failed", len(results)-failed, failed))
+		if failed > 0 {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestBatchCertificateApplyDefaults(t *testing.T) {
+	issuer := &BatchIssuer{Certificate: "ca.cert.pem", Key: "ca.key.pem"}
+	defaults := BatchCertificate{
+		Profile:   "client",
+		Subject:   SubjectFields{Organization: "Acme", Country: "US", CommonName: "default"},
+		San:       []string{"default.test"},
+		Algorithm: "ecdsa-p256",
+		Days:      30,
+		CrlURL:    []string{"http://pki.test/ca.crl"},
+		Output:    "certs",
+		Issuer:    issuer,
+	}
+
+	tests := []struct {
+		name  string
+		entry BatchCertificate
+		want  BatchCertificate
+	}{
+		{
+			name:  "empty entry",
+			entry: BatchCertificate{},
+			want:  defaults,
+		},
+		{
+			name: "subject fields are merged",
+			entry: BatchCertificate{
+				Subject: SubjectFields{CommonName: "b"},
+			},
+			want: BatchCertificate{
+				Profile:   "client",
+				Subject:   SubjectFields{Organization: "Acme", Country: "US", CommonName: "b"},
+				San:       []string{"default.test"},
+				Algorithm: "ecdsa-p256",
+				Days:      30,
+				CrlURL:    []string{"http://pki.test/ca.crl"},
+				Output:    "certs",
+				Issuer:    issuer,
+			},
+		},
+		{
+			name: "entry values take precedence",
+			entry: BatchCertificate{
+				Subject:   SubjectFields{Organization: "Other"},
+				San:       []string{"api.test"},
+				Algorithm: "rsa",
+				Days:      7,
+				CrlURL:    []string{"http://other.test/ca.crl"},
+			},
+			want: BatchCertificate{
+				Profile:   "client",
+				Subject:   SubjectFields{Organization: "Other", Country: "US"},
+				San:       []string{"api.test"},
+				Algorithm: "rsa",
+				Days:      7,
+				CrlURL:    []string{"http://other.test/ca.crl"},
+				Output:    "certs",
+				Issuer:    issuer,
+			},
+		},
+		{
+			name: "common name is not inherited with entry hosts",
+			entry: BatchCertificate{
+				San: []string{"api.test"},
+			},
+			want: BatchCertificate{
+				Profile:   "client",
+				Subject:   SubjectFields{Organization: "Acme", Country: "US"},
+				San:       []string{"api.test"},
+				Algorithm: "ecdsa-p256",
+				Days:      30,
+				CrlURL:    []string{"http://pki.test/ca.crl"},
+				Output:    "certs",
+				Issuer:    issuer,
+			},
+		},
+		{
+			name: "distinguished name is not merged",
+			entry: BatchCertificate{
+				Subject: SubjectFields{DN: "/O=Other/CN=dn"},
+			},
+			want: BatchCertificate{
+				Profile:   "client",
+				Subject:   SubjectFields{DN: "/O=Other/CN=dn"},
+				San:       []string{"default.test"},
+				Algorithm: "ecdsa-p256",
+				Days:      30,
+				CrlURL:    []string{"http://pki.test/ca.crl"},
+				Output:    "certs",
+				Issuer:    issuer,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.applyDefaults(defaults); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyDefaults() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateBatchEntries(t *testing.T) {
+	issuer := &BatchIssuer{Certificate: "/ca/ca.cert.pem", Key: "/ca/ca.key.pem"}
+
+	tests := []struct {
+		name     string
+		entries  []BatchCertificate
+		profiles batchProfiles
+		err      string
+	}{
+		{"unique names", []BatchCertificate{
+			{San: []string{"a.test"}, Output: "/out"},
+			{San: []string{"b.test"}, Output: "/out"},
+			{San: []string{"a.test"}, Output: "/other"},
+			{Name: "a", San: []string{"a.test"}, Output: "/out", Validity: "36h"},
+		}, nil, ""},
+		{"authorities are suffixed", []BatchCertificate{
+			{San: []string{"a.test"}, Output: "/out"},
+			{Subject: SubjectFields{CommonName: "a.test"}, Profile: "ca", Output: "/out"},
+		}, nil, ""},
+		{"manifest authorities are suffixed", []BatchCertificate{
+			{San: []string{"a.test"}, Output: "/out"},
+			{Subject: SubjectFields{CommonName: "a.test"}, Profile: "Intermediate", Output: "/out"},
+		}, batchProfiles{"intermediate": {Authority: true}}, ""},
+		{"negative validity", []BatchCertificate{
+			{San: []string{"a.test"}},
+			{San: []string{"b.test"}, Validity: "-1h"},
+		}, nil, "certificates[1]: invalid validity '-1h'"},
+		{"zero validity", []BatchCertificate{
+			{San: []string{"a.test"}, Validity: "0s"},
+		}, nil, "certificates[0]: invalid validity '0s'"},
+		{"invalid validity", []BatchCertificate{
+			{San: []string{"a.test"}, Validity: "90 days"},
+		}, nil, "certificates[0]: invalid validity '90 days'"},
+		{"same common name", []BatchCertificate{
+			{San: []string{"a.test"}, Output: "/out"},
+			{Subject: SubjectFields{CommonName: "a.test"}, Output: "/out"},
+		}, nil, "certificates[0] and certificates[1] are both saved to /out/a.test.cert.pem"},
+		{"same distinguished name", []BatchCertificate{
+			{Name: "a.test", Output: "/out"},
+			{Subject: SubjectFields{DN: "/O=Acme/CN=a.test"}, Output: "/out"},
+		}, nil, "are both saved to /out/a.test.cert.pem"},
+		{"file override", []BatchCertificate{
+			{San: []string{"a.test"}, Output: "/out"},
+			{San: []string{"b.test"}, Output: "/out", Files: BatchFiles{Key: "a.test.key.pem"}},
+		}, nil, "are both saved to /out/a.test.key.pem"},
+		{"chain files", []BatchCertificate{
+			{San: []string{"a.test"}, Output: "/out", Issuer: issuer, Files: BatchFiles{Chain: "chain.pem"}},
+			{San: []string{"b.test"}, Output: "/out", Issuer: issuer, Files: BatchFiles{Chain: "chain.pem"}},
+		}, nil, "are both saved to /out/chain.pem"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBatchEntries(tt.entries, tt.profiles)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("validateBatchEntries() = %v, want nil", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("validateBatchEntries() = %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBatchManifest(t *testing.T) {
+	dir := t.TempDir()
+	_, cert, key := testAuthority(t, dir, "batch-root")
+	keyMode = "0600"
+
+	manifest := BatchManifest{
+		Issuer: &BatchIssuer{Certificate: filepath.Base(cert), Key: filepath.Base(key)},
+		Defaults: BatchCertificate{
+			Algorithm: "ecdsa-p256",
+			Days:      30,
+			Subject:   SubjectFields{Organization: "Acme"},
+		},
+		Certificates: []BatchCertificate{
+			{Subject: SubjectFields{CommonName: "b"}, San: []string{"b.test"}},
+			{Name: "web", San: []string{"web.test", "10.0.0.1"}, Output: "web"},
+		},
+	}
+
+	for _, format := range []string{"yaml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			marshal := yaml.Marshal
+			if format == "json" {
+				marshal = json.Marshal
+			}
+
+			data, err := marshal(manifest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file := filepath.Join(dir, "manifest."+format)
+			if err = os.WriteFile(file, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// The parsed manifest encodes to the same document
+			parsed := parseBatchManifest(file)
+			if encoded, _ := marshal(parsed); string(encoded) != string(data) {
+				t.Fatalf("parsed manifest = %s, want %s", encoded, data)
+			}
+
+			// Relative paths are resolved from the manifest directory
+			parsed.Defaults.Output = format
+			results := issueBatch(parsed, dir, 2)
+			for _, r := range results {
+				if r.err != nil {
+					t.Fatalf("%s: %v", r.name, r.err)
+				}
+			}
+
+			b := testCertificate(t, filepath.Join(dir, format, "b.cert.pem"))
+			if b.Subject.CommonName != "b" || !reflect.DeepEqual(b.Subject.Organization, []string{"Acme"}) {
+				t.Errorf("subject = %s, want CN=b,O=Acme", b.Subject)
+			}
+			if b.Issuer.CommonName != "batch-root" {
+				t.Errorf("issuer = %s, want CN=batch-root", b.Issuer)
+			}
+
+			web := testCertificate(t, filepath.Join(dir, "web", "web.cert.pem"))
+			if web.Subject.CommonName != "web.test" || len(web.IPAddresses) != 1 {
+				t.Errorf("subject = %s with IP addresses %v, want CN=web.test with 1 IP address", web.Subject, web.IPAddresses)
+			}
+			for _, name := range []string{"web.key.pem", "web.chain.pem", "web.fullchain.pem"} {
+				if !fileExists(filepath.Join(dir, "web", name)) {
+					t.Errorf("file %s was not saved", name)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchExtensionConflict(t *testing.T) {
+	dir := t.TempDir()
+	keyMode = "0600"
+
+	entry := BatchCertificate{
+		San:        []string{"conflict.test"},
+		Algorithm:  "ecdsa-p256",
+		Days:       1,
+		Policies:   []string{"domainValidated"},
+		Extensions: []string{"2.5.29.32=hex:3000"},
+		Output:     dir,
+	}
+	results := issueBatch(BatchManifest{Certificates: []BatchCertificate{entry}}, dir, 1)
+	if len(results) != 1 || results[0].err == nil || !strings.Contains(results[0].err.Error(), "also set by other certificate options") {
+		t.Fatalf("results = %+v, want a conflicting extension error", results)
+	}
+	if fileExists(filepath.Join(dir, "conflict.test.cert.pem")) {
+		t.Error("certificate with conflicting extensions was saved")
+	}
+}
+
+func TestParseBatchProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles map[string]BatchProfile
+		err      string
+	}{
+		{"empty", nil, ""},
+		{"extends built-in profile", map[string]BatchProfile{
+			"web":     {Extends: "cert", CrlURL: []string{"http://pki.test/ca.crl"}},
+			"signing": {Extends: "code-signing"},
+		}, ""},
+		{"unknown base profile", map[string]BatchProfile{
+			"web": {Extends: "server"},
+		}, "profiles.web: unknown profile 'server'"},
+		{"relative URL", map[string]BatchProfile{
+			"web": {OcspURL: []string{"/ocsp"}},
+		}, "profiles.web: invalid URL '/ocsp'"},
+		{"invalid policy", map[string]BatchProfile{
+			"web": {Policies: []string{"unknownPolicy"}},
+		}, "profiles.web:"},
+		{"invalid extension", map[string]BatchProfile{
+			"web": {Extensions: []string{"1.2.3.4=hex:zz"}},
+		}, "profiles.web:"},
+		{"built-in name", map[string]BatchProfile{
+			"SMIME": {},
+		}, "profiles.SMIME: a built-in profile has the same name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profiles, err := parseBatchProfiles(tt.profiles)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("parseBatchProfiles() = %v, want nil", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("parseBatchProfiles() = %v, want error containing %q", err, tt.err)
+			case tt.err == "" && len(profiles) != len(tt.profiles):
+				t.Errorf("parseBatchProfiles() = %d profiles, want %d", len(profiles), len(tt.profiles))
+			}
+		})
+	}
+
+	profiles, _ := parseBatchProfiles(map[string]BatchProfile{"signing": {Extends: "code-signing", IssuerURL: []string{"http://pki.test/ca.crt"}}})
+	signing, err := profiles.lookup(" Signing ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signing.KeyUsage != x509.KeyUsageDigitalSignature || !signing.SubjectOnly || !reflect.DeepEqual(signing.IssuingCertificateURL, []string{"http://pki.test/ca.crt"}) {
+		t.Errorf("lookup() = %+v, want code signing profile with an issuer URL", signing)
+	}
+	if _, err = profiles.lookup("timestamping"); err != nil {
+		t.Errorf("lookup() = %v, want built-in profile", err)
+	}
+}
+
+func TestBatchManifestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	_, cert, key := testAuthority(t, dir, "profile-root")
+	keyMode = "0600"
+
+	manifest := `
+issuer:
+    certificate: ` + filepath.Base(cert) + `
+    key: ` + filepath.Base(key) + `
+profiles:
+    web:
+        extends: cert
+        issuerURL: [http://pki.test/ca.crt]
+        ocspURL: [http://pki.test/ocsp]
+        crlURL: [http://pki.test/ca.crl]
+        policies: [domainValidated]
+        extensions: ['1.3.6.1.4.1.311.20.2=bmp:WebServer']
+    sub-ca:
+        extends: ca
+        crlURL: [http://pki.test/root.crl]
+defaults:
+    algorithm: ecdsa-p256
+    days: 30
+    output: certs
+certificates:
+    - san: [web.test]
+      profile: web
+    - san: [api.test]
+      profile: web
+      crlURL: [http://api.test/ca.crl]
+      policies: [organizationValidated]
+    - subject:
+          commonName: Intermediate
+      profile: sub-ca
+`
+	file := filepath.Join(dir, "manifest.yaml")
+	if err := os.WriteFile(file, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range issueBatch(parseBatchManifest(file), dir, 2) {
+		if r.err != nil {
+			t.Fatalf("%s: %v", r.name, r.err)
+		}
+	}
+
+	web := testCertificate(t, filepath.Join(dir, "certs", "web.test.cert.pem"))
+	if !reflect.DeepEqual(web.IssuingCertificateURL, []string{"http://pki.test/ca.crt"}) ||
+		!reflect.DeepEqual(web.OCSPServer, []string{"http://pki.test/ocsp"}) ||
+		!reflect.DeepEqual(web.CRLDistributionPoints, []string{"http://pki.test/ca.crl"}) {
+		t.Errorf("web URLs = %v %v %v, want profile URLs", web.IssuingCertificateURL, web.OCSPServer, web.CRLDistributionPoints)
+	}
+	if !reflect.DeepEqual(web.PolicyIdentifiers, []asn1.ObjectIdentifier{{2, 23, 140, 1, 2, 1}}) {
+		t.Errorf("web policies = %v, want profile policy", web.PolicyIdentifiers)
+	}
+	if !hasExtension(web.Extensions, asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2}) {
+		t.Error("web certificate does not have the profile extension")
+	}
+
+	// Entry URLs take precedence over profile URLs
+	api := testCertificate(t, filepath.Join(dir, "certs", "api.test.cert.pem"))
+	if !reflect.DeepEqual(api.CRLDistributionPoints, []string{"http://api.test/ca.crl"}) || len(api.OCSPServer) != 1 {
+		t.Errorf("api URLs = %v %v, want entry CRL URL and profile OCSP URL", api.OCSPServer, api.CRLDistributionPoints)
+	}
+	if !reflect.DeepEqual(api.PolicyIdentifiers, []asn1.ObjectIdentifier{{2, 23, 140, 1, 2, 2}}) {
+		t.Errorf("api policies = %v, want entry policy", api.PolicyIdentifiers)
+	}
+	if !hasExtension(api.Extensions, asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2}) {
+		t.Error("api certificate does not have the profile extension")
+	}
+
+	// Profiles extending an authority issue authorities
+	ca := testCertificate(t, filepath.Join(dir, "certs", "Intermediate.ca.cert.pem"))
+	if !ca.IsCA || !reflect.DeepEqual(ca.CRLDistributionPoints, []string{"http://pki.test/root.crl"}) {
+		t.Errorf("intermediate = CA %v with CRL %v, want authority with profile CRL URL", ca.IsCA, ca.CRLDistributionPoints)
+	}
+}
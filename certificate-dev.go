@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/lstellway/go/command"
+)
+
+var (
+	// Directory the development certificate authority is stored in
+	devHome string
+
+	// File base name of the development certificate authority
+	devAuthorityName = "acert-development"
+)
+
+// defaultDevHome returns the per-user data directory used
+// to store the development certificate authority.
+func defaultDevHome() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "acert")
+	}
+
+	home, _ := os.UserHomeDir()
+
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return filepath.Join(dir, "acert")
+		}
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "acert")
+	}
+
+	return filepath.Join(home, ".local", "share", "acert")
+}
+
+// devAuthorityFiles returns the certificate and private key paths
+// of the development certificate authority.
+func devAuthorityFiles() (string, string) {
+	name := filepath.Join(devHome, devAuthorityName+".ca")
+	return name + ".cert.pem", name + ".key.pem"
+}
+
+// devAuthorityUser describes the user and host the development authority was created for.
+func devAuthorityUser() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s", name, host)
+}
+
+// devHomeFlags adds the flag used to configure the development authority directory.
+func devHomeFlags(h *command.CommandSection) {
+	h.StringVar(&devHome, "home", defaultDevHome(), "Directory the development certificate authority is stored in")
+}
+
+// devInit creates and trusts the development certificate authority.
+// An existing authority is reused, so the command can safely be run again.
+func devInit() {
+	cert, _ := devAuthorityFiles()
+
+	if fileExists(cert) {
+		log("Using existing development certificate authority:", cert)
+	} else {
+		err := os.MkdirAll(devHome, 0700)
+		exitOnError(err, "Could not create directory:", devHome, err)
+
+		// Save authority files to the development directory
+		outputDirectory = devHome
+		commonName = "acert development CA " + devAuthorityUser()
+		organization = "acert development CA"
+		organizationalUnit = devAuthorityUser()
+
+		a := Acert{
+			Subject: buildSubject(),
+			Options: AcertOptions{
+				Days:      days,
+				Algorithm: keyAlgorithm(),
+				Bits:      bits,
+			},
+		}
+		bytes := a.BuildCertificate(true)
+		saveCertificatePem(devAuthorityName+".ca", bytes, false)
+		savePrivateKeyPem(devAuthorityName+".ca", a.PrivateKey)
+	}
+
+	if trust {
+		TrustTargets(cert)
+	}
+}
+
+// devCertificate issues a certificate for the specified hosts
+// signed by the development certificate authority.
+func devCertificate(hosts []string) {
+	cert, privateKey := devAuthorityFiles()
+	if !fileExists(cert) || !fileExists(privateKey) {
+		exit(1, fmt.Sprintf("Development certificate authority not found in '%s'. Run '%s' first.", devHome, commandName("dev init")))
+	}
+
+	parent, key = cert, privateKey
+	san = strings.Join(hosts, ",")
+	buildAcertCertificate(&Acert{}, false)
+}
+
+// devUninstall untrusts and removes the development certificate authority.
+func devUninstall() {
+	cert, privateKey := devAuthorityFiles()
+	if !fileExists(cert) {
+		exit(1, "Development certificate authority not found:", cert)
+	}
+
+	UntrustTargets(cert)
+
+	for _, file := range []string{cert, privateKey} {
+		err := os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			exit(1, "Could not remove file:", file, err)
+		}
+		log("Removed file:", file)
+	}
+
+	// Remove the directory when nothing else is stored in it
+	os.Remove(devHome)
+}
+
+// devCommand handles command-line input arguments to manage
+// a local development certificate authority.
+func devCommand(flags ...string) {
+	// Initialize command
+	cmd, args = newCommand(commandName("dev"), "Manage a local development certificate authority", func(h *command.Command) {
+		h.AddSubcommand("init", "Create and trust the development certificate authority")
+		h.AddSubcommand("cert", "Create a certificate signed by the development certificate authority")
+		h.AddSubcommand("uninstall", "Untrust and remove the development certificate authority")
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	switch getArgument(true) {
+	case "init":
+		cmd, args = newCommand(commandName("dev init"), "Create and trust the development certificate authority", func(h *command.Command) {
+			h.AddSection("General Options", func(s *command.CommandSection) {
+				devHomeFlags(s)
+				s.StringVar(&keyMode, "keyMode", "0600", "File mode used when saving private keys (octal)")
+			})
+			h.AddSection("Private Key Options", func(s *command.CommandSection) {
+				certificateKeyFlags(s)
+			})
+			h.AddSection("Certificate Options", func(s *command.CommandSection) {
+				s.IntVar(&days, "days", 3650, "Number of days the certificate authority should be valid for")
+				s.BoolVar(&trust, "trust", true, "Trust the certificate authority")
+				trustTargetFlags(s)
+			})
+
+			h.AddSubcommand("help", "Display this help screen")
+		}, args...)
+
+		switch getArgument(true) {
+		case "help":
+			cmd.Usage()
+		default:
+			devInit()
+		}
+	case "cert", "certificate":
+		cmd, args = newCommand(commandName("dev cert"), "Create a certificate signed by the development certificate authority", func(h *command.Command) {
+			h.AddSection("General Options", func(s *command.CommandSection) {
+				generalFlags(s)
+				devHomeFlags(s)
+			})
+			h.AddSection("Subject Name Options", func(s *command.CommandSection) {
+				certificateSubjectFlags(s)
+			})
+			h.AddSection("Private Key Options", func(s *command.CommandSection) {
+				certificateKeyFlags(s)
+			})
+			h.AddSection("Certificate Options", func(s *command.CommandSection) {
+				s.IntVar(&days, "days", 90, "Number of days generated certificates should be valid for")
+				certificateValidityFlags(s)
+			})
+
+			h.AddArgument("HOSTS...")
+
+			h.AddExample("Create a certificate for local hosts", "test.local '*.test.local' localhost 127.0.0.1 ::1")
+
+			h.AddSubcommand("help", "Display this help screen")
+		}, args...)
+
+		switch getArgument(false) {
+		case "", "help":
+			cmd.Usage()
+		default:
+			devCertificate(args)
+		}
+	case "uninstall":
+		cmd, args = newCommand(commandName("dev uninstall"), "Untrust and remove the development certificate authority", func(h *command.Command) {
+			h.AddSection("General Options", func(s *command.CommandSection) {
+				devHomeFlags(s)
+				trustTargetFlags(s)
+			})
+
+			h.AddSubcommand("help", "Display this help screen")
+		}, args...)
+
+		switch getArgument(true) {
+		case "help":
+			cmd.Usage()
+		default:
+			devUninstall()
+		}
+	default:
+		cmd.Usage()
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDefaultDevHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, "data"))
+	if dir := defaultDevHome(); dir != filepath.Join(home, "data", "acert") {
+		t.Errorf("defaultDevHome() = %s, want the XDG data directory", dir)
+	}
+
+	t.Setenv("XDG_DATA_HOME", "")
+	want := filepath.Join(home, ".local", "share", "acert")
+	switch runtime.GOOS {
+	case "darwin":
+		want = filepath.Join(home, "Library", "Application Support", "acert")
+	case "windows":
+		t.Skip("the home directory is not configured with HOME on Windows")
+	}
+	if dir := defaultDevHome(); dir != want {
+		t.Errorf("defaultDevHome() = %s, want %s", dir, want)
+	}
+}
+
+// testDevOptions sets the options used by the dev command and restores them after the test.
+func testDevOptions(t *testing.T, home string) {
+	t.Helper()
+
+	values := []*string{&devHome, &keyMode, &trustTarget, &outputDirectory, &commonName, &organization, &organizationalUnit, &san, &parent, &key}
+	previous := make([]string, len(values))
+	for i, s := range values {
+		previous[i] = *s
+	}
+	previousDays, previousTrust, previousEd25519 := days, trust, isEd25519
+	t.Cleanup(func() {
+		for i, s := range values {
+			*s = previous[i]
+		}
+		days, trust, isEd25519 = previousDays, previousTrust, previousEd25519
+	})
+
+	devHome, keyMode, trustTarget = home, "0600", "system"
+	days, trust, isEd25519 = 30, true, true
+}
+
+func TestDevAuthority(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "anchors")
+	testTrustStore(t, store, "")
+	testDevOptions(t, filepath.Join(dir, "dev"))
+
+	// The authority is created with a private key only readable by the user and trusted
+	devInit()
+	cert, privateKey := devAuthorityFiles()
+	authority := testCertificate(t, cert)
+	if !authority.IsCA || !strings.HasPrefix(authority.Subject.CommonName, "acert development CA ") {
+		t.Errorf("authority = %s (CA %v), want a development certificate authority", authority.Subject, authority.IsCA)
+	}
+	if info, err := os.Stat(privateKey); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("private key mode = %v, %v, want 0600", info, err)
+	}
+	if anchors, _ := os.ReadDir(store); len(anchors) != 1 {
+		t.Errorf("trust store contains %d anchor(s), want 1", len(anchors))
+	}
+
+	// Running init again keeps the existing authority
+	devInit()
+	if again := testCertificate(t, cert); !again.Equal(authority) {
+		t.Error("init replaced the existing authority")
+	}
+
+	// Certificates are signed by the authority
+	outputDirectory, commonName, organization, organizationalUnit, trust = dir, "", "", "", false
+	devCertificate([]string{"test.local", "127.0.0.1"})
+	issued := testCertificate(t, filepath.Join(dir, "test.local.cert.pem"))
+	roots := x509.NewCertPool()
+	roots.AddCert(authority)
+	if _, err := issued.Verify(x509.VerifyOptions{DNSName: "test.local", Roots: roots}); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	if len(issued.IPAddresses) != 1 {
+		t.Errorf("IP addresses = %v, want 127.0.0.1", issued.IPAddresses)
+	}
+
+	// Uninstall untrusts the authority and removes its directory
+	devUninstall()
+	for _, file := range []string{cert, privateKey, devHome} {
+		if fileExists(file) {
+			t.Errorf("%s was not removed", file)
+		}
+	}
+	if anchors, _ := os.ReadDir(store); len(anchors) != 0 {
+		t.Errorf("trust store contains %d anchor(s), want 0", len(anchors))
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	// Certificate policies extension and CPS pointer qualifier
+	// https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.1.4
+	oidExtensionCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidPolicyQualifierCPS           = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+)
+
+// policyNames maps lower case names to well-known policy identifiers
+var policyNames = map[string]asn1.ObjectIdentifier{
+	"anypolicy":             {2, 5, 29, 32, 0},
+	"domainvalidated":       {2, 23, 140, 1, 2, 1},
+	"organizationvalidated": {2, 23, 140, 1, 2, 2},
+	"individualvalidated":   {2, 23, 140, 1, 2, 3},
+	"extendedvalidation":    {2, 23, 140, 1, 1},
+}
+
+// CertificatePolicy is a policy identifier with optional
+// certification practice statement (CPS) URIs
+type CertificatePolicy struct {
+	Identifier asn1.ObjectIdentifier
+	CPS        []string
+}
+
+// ASN.1 structures of the certificate policies extension
+type policyQualifierInfo struct {
+	PolicyQualifierID asn1.ObjectIdentifier
+	Qualifier         string `asn1:"ia5"`
+}
+
+type policyInformation struct {
+	PolicyIdentifier asn1.ObjectIdentifier
+	PolicyQualifiers []policyQualifierInfo `asn1:"optional,omitempty"`
+}
+
+// ParseCertificatePolicies parses policies formatted as OID[=CPS_URI].
+// Policies can be referenced by name (eg, domainValidated), and
+// repeated identifiers are merged into a single policy.
+func ParseCertificatePolicies(values []string) ([]CertificatePolicy, error) {
+	var policies []CertificatePolicy
+
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		name := strings.TrimSpace(parts[0])
+
+		oid, ok := policyNames[strings.ToLower(name)]
+		if !ok {
+			var err error
+			if oid, err = parseObjectIdentifier(name); err != nil && strings.Contains(name, ".") {
+				return nil, err
+			} else if err != nil {
+				return nil, fmt.Errorf("unknown certificate policy '%s'", name)
+			}
+		}
+
+		var cps []string
+		if len(parts) == 2 {
+			uri := strings.TrimSpace(parts[1])
+			if _, err := parseURLs([]string{uri}); err != nil {
+				return nil, err
+			}
+			cps = append(cps, uri)
+		}
+
+		merged := false
+		for i := range policies {
+			if policies[i].Identifier.Equal(oid) {
+				policies[i].CPS = append(policies[i].CPS, cps...)
+				merged = true
+			}
+		}
+		if !merged {
+			policies = append(policies, CertificatePolicy{Identifier: oid, CPS: cps})
+		}
+	}
+
+	return policies, nil
+}
+
+// certificatePoliciesExtension encodes the certificate policies extension.
+// The extension is built here as x509 templates do not support policy qualifiers.
+func certificatePoliciesExtension(policies []CertificatePolicy) (pkix.Extension, error) {
+	var information []policyInformation
+	for _, policy := range policies {
+		info := policyInformation{PolicyIdentifier: policy.Identifier}
+		for _, uri := range policy.CPS {
+			info.PolicyQualifiers = append(info.PolicyQualifiers, policyQualifierInfo{oidPolicyQualifierCPS, uri})
+		}
+		information = append(information, info)
+	}
+
+	value, err := asn1.Marshal(information)
+	return pkix.Extension{Id: oidExtensionCertificatePolicies, Value: value}, err
+}
+
+// ParseExtensions parses extensions formatted as [critical:]OID=TYPE:VALUE.
+// Values are DER-encoded using the hex or base64 types, or encoded from
+// utf8, ia5, printable, bmp, int, bool, oid or null (without a value) types.
+func ParseExtensions(values []string) ([]pkix.Extension, error) {
+	var extensions []pkix.Extension
+
+	for _, value := range values {
+		var extension pkix.Extension
+
+		if strings.HasPrefix(strings.ToLower(value), "critical:") {
+			extension.Critical = true
+			value = value[len("critical:"):]
+		}
+
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid extension '%s', expected OID=TYPE:VALUE", value)
+		}
+
+		oid, err := parseObjectIdentifier(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		if hasExtension(extensions, oid) {
+			return nil, fmt.Errorf("extension '%s' is set more than once", oid)
+		}
+
+		extension.Id = oid
+		if extension.Value, err = parseExtensionValue(strings.TrimSpace(parts[1])); err != nil {
+			return nil, fmt.Errorf("invalid value for extension '%s': %v", oid, err)
+		}
+		extensions = append(extensions, extension)
+	}
+
+	return extensions, nil
+}
+
+// hasExtension reports whether an extension with the identifier is in the list
+func hasExtension(extensions []pkix.Extension, id asn1.ObjectIdentifier) bool {
+	for _, e := range extensions {
+		if e.Id.Equal(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseExtensionValue encodes a TYPE:VALUE extension value as DER
+func parseExtensionValue(value string) ([]byte, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("expected TYPE:VALUE")
+	}
+	kind, data := strings.ToLower(parts[0]), parts[1]
+
+	switch kind {
+	case "hex", "der":
+		der, err := hex.DecodeString(strings.ReplaceAll(data, ":", ""))
+		if err != nil {
+			return nil, err
+		}
+		return der, requireSingleDerValue(der)
+	case "base64":
+		der, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, err
+		}
+		return der, requireSingleDerValue(der)
+	case "utf8", "ia5", "printable":
+		return asn1.MarshalWithParams(data, kind)
+	case "bmp":
+		var b []byte
+		for _, c := range utf16.Encode([]rune(data)) {
+			b = append(b, byte(c>>8), byte(c))
+		}
+		return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: 30, Bytes: b})
+	case "int":
+		n, ok := new(big.Int).SetString(strings.TrimSpace(data), 0)
+		if !ok {
+			return nil, fmt.Errorf("invalid integer '%s'", data)
+		}
+		return asn1.Marshal(n)
+	case "bool":
+		b, err := strconv.ParseBool(strings.TrimSpace(data))
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(b)
+	case "oid":
+		oid, err := parseObjectIdentifier(data)
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(oid)
+	case "null":
+		if data != "" {
+			return nil, fmt.Errorf("null values cannot have data")
+		}
+		return asn1.NullBytes, nil
+	}
+
+	return nil, fmt.Errorf("unknown type '%s'", kind)
+}
+
+// requireSingleDerValue checks that data is a single DER-encoded value
+func requireSingleDerValue(der []byte) error {
+	var value asn1.RawValue
+	rest, err := asn1.Unmarshal(der, &value)
+	if err != nil {
+		return fmt.Errorf("invalid DER encoding: %v", err)
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("invalid DER encoding: %d trailing bytes", len(rest))
+	}
+	return nil
+}
+
+// CheckExtensions reports custom extensions that conflict with extensions built from other options.
+// The certificate policies, critical extended key usage and subject alternative names that
+// x509 templates do not support are added as extra extensions and cannot be replaced.
+func (a *Acert) CheckExtensions() error {
+	generated := map[string]bool{}
+	if len(a.Options.Policies) > 0 {
+		generated[oidExtensionCertificatePolicies.String()] = true
+	}
+	if a.Options.CriticalExtKeyUsage && (len(a.Options.ExtKeyUsage) > 0 || len(a.Options.UnknownExtKeyUsage) > 0) {
+		generated[oidExtensionExtKeyUsage.String()] = true
+	}
+	for _, value := range a.Hosts {
+		if kind, _, err := ParseSubjectAlternativeName(value); err == nil && (kind == "upn" || kind == "rid") {
+			generated[oidExtensionSubjectAltName.String()] = true
+		}
+	}
+	for _, extension := range a.Request.Extensions {
+		if extension.Id.Equal(oidExtensionSubjectAltName) && hasCustomSubjectAlternativeNames(extension) {
+			generated[oidExtensionSubjectAltName.String()] = true
+		}
+	}
+
+	for _, extension := range a.Options.Extensions {
+		if generated[extension.Id.String()] {
+			return fmt.Errorf("extension '%s' is also set by other certificate options", extension.Id)
+		}
+	}
+	return nil
+}
+
+// SetExtensions adds the certificate policies and custom extensions to the certificate.
+// Custom extensions replace extensions built from the certificate template fields (eg, key usage),
+// but panic when they conflict with other extra extensions. Callers are expected to check
+// the extensions with CheckExtensions first.
+func (a *Acert) SetExtensions() {
+	extensions := a.Options.Extensions
+
+	if len(a.Options.Policies) > 0 {
+		policies, err := certificatePoliciesExtension(a.Options.Policies)
+		if err != nil {
+			panic(err)
+		}
+		extensions = append([]pkix.Extension{policies}, extensions...)
+	}
+
+	for _, extension := range extensions {
+		for _, e := range a.Certificate.ExtraExtensions {
+			if e.Id.Equal(extension.Id) {
+				panic(fmt.Errorf("extension '%s' is set more than once", extension.Id))
+			}
+		}
+		a.Certificate.ExtraExtensions = append(a.Certificate.ExtraExtensions, extension)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestCheckExtensions(t *testing.T) {
+	extension := func(id asn1.ObjectIdentifier, value ...byte) []pkix.Extension {
+		if len(value) == 0 {
+			value = []byte{0x30, 0x00}
+		}
+		return []pkix.Extension{{Id: id, Value: value}}
+	}
+	policies := []CertificatePolicy{{Identifier: asn1.ObjectIdentifier{2, 23, 140, 1, 2, 1}}}
+	customSan, err := subjectAlternativeNameExtension([]string{"rid:1.2.3"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		acert   Acert
+		invalid bool
+	}{
+		{"no extensions", Acert{Hosts: []string{"rid:1.2.3"}, Options: AcertOptions{Policies: policies}}, false},
+		{"template field", Acert{Options: AcertOptions{KeyUsage: x509.KeyUsageDigitalSignature, Extensions: extension(asn1.ObjectIdentifier{2, 5, 29, 15}, 0x03, 0x02, 0x07, 0x80)}}, false},
+		{"dns names", Acert{Hosts: []string{"test.com"}, Options: AcertOptions{Extensions: extension(oidExtensionSubjectAltName)}}, false},
+		{"policies", Acert{Options: AcertOptions{Policies: policies, Extensions: extension(oidExtensionCertificatePolicies)}}, true},
+		{"custom names", Acert{Hosts: []string{"test.com", "rid:1.2.3"}, Options: AcertOptions{Extensions: extension(oidExtensionSubjectAltName)}}, true},
+		{"request custom names", Acert{Request: x509.CertificateRequest{Extensions: []pkix.Extension{customSan}}, Options: AcertOptions{Extensions: extension(oidExtensionSubjectAltName)}}, true},
+		{"critical usage", Acert{Options: AcertOptions{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}, CriticalExtKeyUsage: true, Extensions: extension(oidExtensionExtKeyUsage)}}, true},
+		{"non-critical usage", Acert{Options: AcertOptions{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, Extensions: extension(oidExtensionExtKeyUsage)}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.acert.CheckExtensions()
+			if (err != nil) != tt.invalid {
+				t.Fatalf("CheckExtensions() = %v, want error %v", err, tt.invalid)
+			}
+			if err != nil {
+				return
+			}
+
+			// Checked extensions can be built without panicking
+			a := tt.acert
+			a.Options.Algorithm = "ecdsa-p256"
+			a.Options.Days = 1
+			if _, err := x509.ParseCertificate(a.BuildCertificate(false)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/lstellway/go/command"
+)
+
+// MatchedFile describes the public key found in a key, certificate or signing request file
+type MatchedFile struct {
+	File      string
+	Type      string
+	Algorithm string
+	PublicKey crypto.PublicKey
+}
+
+// publicKeyFingerprint returns the hex-encoded SHA-256 hash of a DER-encoded public key
+func publicKeyFingerprint(key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "-"
+	}
+	return fmt.Sprintf("%X", sha256.Sum256(der))
+}
+
+// readMatchedFile returns the public key of the first key, certificate
+// or signing request in a PEM-encoded file.
+// For certificate chains, the first certificate is the leaf certificate.
+func readMatchedFile(file string) (MatchedFile, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return MatchedFile{}, err
+	}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return MatchedFile{}, fmt.Errorf("no key, certificate or signing request found in file: %s", file)
+		}
+
+		m := MatchedFile{File: file}
+		switch block.Type {
+		case "CERTIFICATE":
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return m, err
+			}
+			m.Type, m.PublicKey = "certificate", c.PublicKey
+		case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+			request, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil {
+				return m, err
+			}
+			m.Type, m.PublicKey = "request", request.PublicKey
+		case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+			key, err := parsePrivateKeyBlock(block)
+			if err != nil {
+				return m, err
+			}
+			m.Type, m.PublicKey = "key", publicKey(key)
+		default:
+			continue
+		}
+
+		m.Algorithm = keyAlgorithmName(m.PublicKey)
+		return m, nil
+	}
+}
+
+// MatchPublicKeys reports whether every file contains the same public key
+func MatchPublicKeys(files []MatchedFile) bool {
+	for _, f := range files[1:] {
+		if !publicKeysMatch(files[0].PublicKey, f.PublicKey) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchFiles defines the CLI command to check that keys, certificates and requests correspond
+func matchFiles(flags ...string) {
+	// Initialize command
+	cmd, args = newCommand(commandName("match"), "Check that private keys, certificates and signing requests share a public key", func(h *command.Command) {
+		h.AddArgument("FILE...")
+
+		h.AddExample("Check a private key belongs to a certificate", "test.com.key.pem test.com.cert.pem")
+		h.AddExample("Check a renewed certificate was issued for a signing request", "test.com.csr.pem test.com.key.pem test.com.cert.pem")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	switch getArgument(false) {
+	case "", "help":
+		cmd.Usage()
+	default:
+		if len(args) < 2 {
+			exit(1, "At least two files are required to compare public keys")
+		}
+
+		var files []MatchedFile
+		for _, file := range args {
+			m, err := readMatchedFile(file)
+			exitOnError(err, "Could not read file:", file, err)
+			files = append(files, m)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TYPE\tALGORITHM\tPUBLIC KEY (SHA-256)\tFILE")
+		for _, f := range files {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Type, f.Algorithm, publicKeyFingerprint(f.PublicKey), f.File)
+		}
+		w.Flush()
+
+		if !MatchPublicKeys(files) {
+			exit(1, "\nPublic keys do not match")
+		}
+		log("\nPublic keys match")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testMatchFiles saves a private key with a self-signed certificate and a signing request for it.
+func testMatchFiles(t *testing.T, dir string, algorithm string) (string, string, string) {
+	t.Helper()
+
+	a := &Acert{Subject: pkix.Name{CommonName: algorithm}, Options: AcertOptions{Algorithm: algorithm, Bits: 2048, Days: 1}}
+	request := a.BuildCertificateRequest()
+	cert := a.BuildCertificate(false)
+
+	name := filepath.Join(dir, algorithm)
+	for file, data := range map[string][]byte{
+		name + ".key.pem":  pemEncode("PRIVATE KEY", privateKeyPkcs(a.PrivateKey)),
+		name + ".cert.pem": pemEncode("CERTIFICATE", cert),
+		name + ".csr.pem":  pemEncode("CERTIFICATE REQUEST", request),
+	} {
+		if err := os.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return name + ".key.pem", name + ".cert.pem", name + ".csr.pem"
+}
+
+// testMatchedFiles reads the public keys of files.
+func testMatchedFiles(t *testing.T, files ...string) []MatchedFile {
+	t.Helper()
+
+	var matched []MatchedFile
+	for _, file := range files {
+		m, err := readMatchedFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matched = append(matched, m)
+	}
+	return matched
+}
+
+func TestMatchPublicKeys(t *testing.T) {
+	dir := t.TempDir()
+	algorithms := map[string]string{"rsa": "RSA-2048", "ecdsa-p256": "ECDSA-P-256", "ed25519": "Ed25519"}
+
+	for algorithm, name := range algorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			key, cert, csr := testMatchFiles(t, dir, algorithm)
+			otherKey, otherCert, otherCsr := testMatchFiles(t, t.TempDir(), algorithm)
+
+			files := testMatchedFiles(t, key, cert, csr)
+			for i, want := range []string{"key", "certificate", "request"} {
+				if files[i].Type != want || files[i].Algorithm != name {
+					t.Errorf("%s type = %s (%s), want %s (%s)", files[i].File, files[i].Type, files[i].Algorithm, want, name)
+				}
+			}
+
+			tests := []struct {
+				files []string
+				want  bool
+			}{
+				{[]string{key, cert}, true},
+				{[]string{cert, csr}, true},
+				{[]string{key, csr}, true},
+				{[]string{csr, key, cert}, true},
+				{[]string{key, otherCert}, false},
+				{[]string{otherKey, cert}, false},
+				{[]string{cert, otherCsr}, false},
+				{[]string{key, cert, otherCsr}, false},
+			}
+			for _, tt := range tests {
+				if got := MatchPublicKeys(testMatchedFiles(t, tt.files...)); got != tt.want {
+					t.Errorf("MatchPublicKeys(%v) = %v, want %v", tt.files, got, tt.want)
+				}
+			}
+
+			// Keys of other algorithms never match
+			for other := range algorithms {
+				if other == algorithm {
+					continue
+				}
+				otherKey, _, _ := testMatchFiles(t, t.TempDir(), other)
+				if MatchPublicKeys(testMatchedFiles(t, otherKey, cert)) {
+					t.Errorf("%s key matches %s certificate", other, algorithm)
+				}
+			}
+		})
+	}
+}
+
+func TestReadMatchedFile(t *testing.T) {
+	dir := t.TempDir()
+	_, rootCert, rootKey := testAuthority(t, dir, "match-root")
+	cert, _ := testIssue(t, dir, rootCert, rootKey, "match.test")
+
+	// The leaf certificate of a chain is used
+	chain := filepath.Join(dir, "fullchain.pem")
+	if err := os.WriteFile(chain, append(readFile(cert), readFile(rootCert)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := testMatchedFiles(t, chain, cert)
+	if !MatchPublicKeys(m) {
+		t.Error("chain file does not match its leaf certificate")
+	}
+
+	// Files without keys, certificates or requests are rejected
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, pemEncode("X509 CRL", []byte{}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readMatchedFile(empty); err == nil {
+		t.Error("readMatchedFile() succeeded without a key, certificate or request")
+	}
+}
+
+func TestRequireMatchingKey(t *testing.T) {
+	// The mismatched case exits, so it is run in a separate process
+	if files := os.Getenv("ACERT_TEST_REQUIRE_MATCHING_KEY"); files != "" {
+		parts := strings.Split(files, ",")
+		requireMatchingKey(parsePemCertificate(parts[1]), parsePemPrivateKey(parts[0]), parts[0], parts[1])
+		return
+	}
+
+	dir := t.TempDir()
+	key, cert, _ := testMatchFiles(t, dir, "ecdsa-p256")
+	otherKey, _, _ := testMatchFiles(t, t.TempDir(), "ecdsa-p256")
+
+	// Matching keys do not exit
+	requireMatchingKey(parsePemCertificate(cert), parsePemPrivateKey(key), key, cert)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRequireMatchingKey$")
+	cmd.Env = append(os.Environ(), "ACERT_TEST_REQUIRE_MATCHING_KEY="+otherKey+","+cert)
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Fatalf("mismatched key exited with %v, want exit code 1", err)
+	}
+	if !strings.Contains(string(output), "does not match the public key of certificate") {
+		t.Errorf("output = %q, want a mismatched key error", output)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CertificateProfile describes the kind of certificate to issue.
+// Profile usages, URLs, policies and extensions are applied unless they are configured explicitly.
+type CertificateProfile struct {
+	Authority   bool
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+
+	// Some consumers require the extended key usage extension to be critical
+	CriticalExtKeyUsage bool
+
+	// Authority information access and CRL distribution points
+	IssuingCertificateURL []string
+	OCSPServer            []string
+	CRLDistributionPoints []string
+
+	// Certificate policies and custom extensions
+	Policies   []CertificatePolicy
+	Extensions []pkix.Extension
+
+	// Profiles that identify a subject rather than hosts
+	// only require a common name.
+	SubjectOnly bool
+}
+
+// certificateProfiles maps profile names to profiles.
+// Profile names mirror the command names and aliases.
+var certificateProfiles = map[string]CertificateProfile{
+	"":            {},
+	"cert":        {},
+	"certificate": {},
+	"client":      {},
+	"ca":          {Authority: true},
+	"authority":   {Authority: true},
+	"code-signing": {
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		SubjectOnly: true,
+	},
+	// Key encipherment is removed for non-RSA keys, which cannot be used for key transport
+	// https://datatracker.ietf.org/doc/html/rfc8550#section-4.4.2
+	"smime": {
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	},
+	// SPIFFE X.509-SVIDs and trust domain authorities
+	// https://github.com/spiffe/spiffe/blob/main/standards/X509-SVID.md#4-constraints-and-usage
+	"svid": {
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	},
+	"spiffe-ca": {
+		Authority: true,
+		KeyUsage:  x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	},
+	// RFC 3161 requires a critical extended key usage with only time stamping
+	// https://datatracker.ietf.org/doc/html/rfc3161#section-2.3
+	"timestamping": {
+		KeyUsage:            x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:         []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		CriticalExtKeyUsage: true,
+		SubjectOnly:         true,
+	},
+}
+
+// lookupProfile returns the profile with the given name
+func lookupProfile(name string) (CertificateProfile, error) {
+	profile, ok := certificateProfiles[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return profile, fmt.Errorf("unknown profile '%s'", name)
+	}
+	return profile, nil
+}
+
+// profileNames returns the names of the available certificate profiles
+func profileNames() []string {
+	var names []string
+	for name := range certificateProfiles {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Apply sets the profile usages, URLs, policies and extensions on options that do not configure them explicitly.
+// Profile extensions are added unless an extension with the same identifier is already set.
+func (p CertificateProfile) Apply(o *AcertOptions) {
+	if o.KeyUsage == 0 {
+		o.KeyUsage = p.KeyUsage
+	}
+	if len(o.ExtKeyUsage) == 0 && len(o.UnknownExtKeyUsage) == 0 {
+		o.ExtKeyUsage = p.ExtKeyUsage
+		o.CriticalExtKeyUsage = p.CriticalExtKeyUsage
+	}
+	if len(o.IssuingCertificateURL) == 0 {
+		o.IssuingCertificateURL = p.IssuingCertificateURL
+	}
+	if len(o.OCSPServer) == 0 {
+		o.OCSPServer = p.OCSPServer
+	}
+	if len(o.CRLDistributionPoints) == 0 {
+		o.CRLDistributionPoints = p.CRLDistributionPoints
+	}
+	if len(o.Policies) == 0 && !hasExtension(o.Extensions, oidExtensionCertificatePolicies) {
+		o.Policies = p.Policies
+	}
+	for _, extension := range p.Extensions {
+		if !hasExtension(o.Extensions, extension.Id) {
+			o.Extensions = append(o.Extensions, extension)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"reflect"
+	"testing"
+)
+
+func TestCertificateProfileApply(t *testing.T) {
+	p := CertificateProfile{
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		CriticalExtKeyUsage:   true,
+		IssuingCertificateURL: []string{"http://profile.test/ca.crt"},
+		OCSPServer:            []string{"http://profile.test/ocsp"},
+		CRLDistributionPoints: []string{"http://profile.test/ca.crl"},
+	}
+
+	tests := []struct {
+		name    string
+		options AcertOptions
+		want    AcertOptions
+	}{
+		{"empty", AcertOptions{}, AcertOptions{
+			KeyUsage:              p.KeyUsage,
+			ExtKeyUsage:           p.ExtKeyUsage,
+			CriticalExtKeyUsage:   true,
+			IssuingCertificateURL: p.IssuingCertificateURL,
+			OCSPServer:            p.OCSPServer,
+			CRLDistributionPoints: p.CRLDistributionPoints,
+		}},
+		{"explicit", AcertOptions{
+			KeyUsage:              x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			IssuingCertificateURL: []string{"http://flag.test/ca.crt"},
+			OCSPServer:            []string{"http://flag.test/ocsp"},
+			CRLDistributionPoints: []string{"http://flag.test/ca.crl"},
+		}, AcertOptions{
+			KeyUsage:              x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			IssuingCertificateURL: []string{"http://flag.test/ca.crt"},
+			OCSPServer:            []string{"http://flag.test/ocsp"},
+			CRLDistributionPoints: []string{"http://flag.test/ca.crl"},
+		}},
+		{"partial", AcertOptions{
+			CRLDistributionPoints: []string{"http://flag.test/ca.crl"},
+		}, AcertOptions{
+			KeyUsage:              p.KeyUsage,
+			ExtKeyUsage:           p.ExtKeyUsage,
+			CriticalExtKeyUsage:   true,
+			IssuingCertificateURL: p.IssuingCertificateURL,
+			OCSPServer:            p.OCSPServer,
+			CRLDistributionPoints: []string{"http://flag.test/ca.crl"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.options
+			p.Apply(&o)
+			if !reflect.DeepEqual(o, tt.want) {
+				t.Errorf("Apply() = %+v, want %+v", o, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateProfileApplyExtensions(t *testing.T) {
+	template := pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2}, Value: []byte{0x1e, 0x00}}
+	internal := pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}, Value: asn1.NullBytes}
+	policies := []CertificatePolicy{{Identifier: asn1.ObjectIdentifier{2, 23, 140, 1, 2, 1}}}
+	p := CertificateProfile{Policies: policies, Extensions: []pkix.Extension{template, internal}}
+
+	tests := []struct {
+		name    string
+		options AcertOptions
+		want    AcertOptions
+	}{
+		{"empty", AcertOptions{}, AcertOptions{Policies: policies, Extensions: []pkix.Extension{template, internal}}},
+		{"explicit policies", AcertOptions{
+			Policies: []CertificatePolicy{{Identifier: asn1.ObjectIdentifier{2, 5, 29, 32, 0}}},
+		}, AcertOptions{
+			Policies:   []CertificatePolicy{{Identifier: asn1.ObjectIdentifier{2, 5, 29, 32, 0}}},
+			Extensions: []pkix.Extension{template, internal},
+		}},
+		{"explicit policies extension", AcertOptions{
+			Extensions: []pkix.Extension{{Id: oidExtensionCertificatePolicies, Value: []byte{0x30, 0x00}}},
+		}, AcertOptions{
+			Extensions: []pkix.Extension{{Id: oidExtensionCertificatePolicies, Value: []byte{0x30, 0x00}}, template, internal},
+		}},
+		{"explicit extension", AcertOptions{
+			Extensions: []pkix.Extension{{Id: template.Id, Critical: true, Value: []byte{0x1e, 0x00}}},
+		}, AcertOptions{
+			Policies:   policies,
+			Extensions: []pkix.Extension{{Id: template.Id, Critical: true, Value: []byte{0x1e, 0x00}}, internal},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.options
+			p.Apply(&o)
+			if !reflect.DeepEqual(o, tt.want) {
+				t.Errorf("Apply() = %+v, want %+v", o, tt.want)
+			}
+			if a := (Acert{Options: o}); a.CheckExtensions() != nil {
+				t.Errorf("CheckExtensions() = %v, want nil", a.CheckExtensions())
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lstellway/go/command"
+)
+
+var (
+	// TLS handshake options
+	serverName, alpn          string
+	clientCert, clientCertKey string
+	connectTimeout            int
+)
+
+// remoteFlags adds flags used to connect to a TLS endpoint
+func remoteFlags(h *command.CommandSection) {
+	h.StringVar(&serverName, "servername", "", "Server name sent using SNI (defaults to the host being connected to)")
+	h.StringVar(&alpn, "alpn", "", "Comma-delimited application protocols to negotiate using ALPN (eg, h2,http/1.1)")
+	h.StringVar(&clientCert, "clientCert", "", "Path to PEM-encoded client certificate presented for mutual TLS")
+	h.StringVar(&clientCertKey, "clientKey", "", "Path to PEM-encoded private key of the client certificate")
+	h.IntVar(&connectTimeout, "timeout", 10, "Number of seconds to wait for the TLS handshake")
+}
+
+// remoteServerName returns the configured SNI server name or the host of an address
+func remoteServerName(address string) string {
+	if serverName != "" {
+		return serverName
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+// remoteTLSConfig builds the TLS configuration used to connect to an endpoint.
+// Verification is skipped during the handshake so the presented chain
+// can be saved and verified against an acert root afterwards.
+func remoteTLSConfig(address string) *tls.Config {
+	config := &tls.Config{
+		ServerName:         remoteServerName(address),
+		NextProtos:         splitValue(alpn, ","),
+		InsecureSkipVerify: true,
+	}
+
+	if clientCert != "" || clientCertKey != "" {
+		requireFileValue(&clientCert, "clientCert")
+		requireFileValue(&clientCertKey, "clientKey")
+		pair, err := tls.LoadX509KeyPair(clientCert, clientCertKey)
+		exitOnError(err, "Could not load client certificate:", err)
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	return config
+}
+
+// Maximum time to wait for a TLS 1.3 server to reject a client certificate after the handshake
+const remoteProbeTimeout = time.Second
+
+// FetchRemoteChain performs a TLS handshake with an endpoint and
+// returns the certificate chain and connection state it presented.
+func FetchRemoteChain(address string, config *tls.Config, timeout time.Duration) ([]*x509.Certificate, tls.ConnectionState, error) {
+	// Record whether the server requested a client certificate
+	requested := false
+	config = config.Clone()
+	certificates := config.Certificates
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		requested = true
+		if len(certificates) > 0 {
+			return &certificates[0], nil
+		}
+		return &tls.Certificate{}, nil
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, config)
+	if err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, state, fmt.Errorf("no certificates presented by %s", address)
+	}
+
+	// TLS 1.3 servers verify client certificates after the client completes the handshake,
+	// so a rejection is only received when reading from the connection (RFC 8446, section 4.4.2.4)
+	if requested && state.Version == tls.VersionTLS13 {
+		if timeout > remoteProbeTimeout || timeout <= 0 {
+			timeout = remoteProbeTimeout
+		}
+		if err = probeConnection(conn, timeout); err != nil {
+			return nil, state, err
+		}
+	}
+
+	return state.PeerCertificates, state, nil
+}
+
+// probeConnection reads from a connection to receive alerts sent after the handshake.
+// Connections that stay open without data, send data or are closed are accepted.
+func probeConnection(conn *tls.Conn, timeout time.Duration) error {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	_, err := conn.Read(make([]byte, 1))
+	var netErr net.Error
+	if err == nil || errors.Is(err, io.EOF) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return nil
+	}
+	return err
+}
+
+// fetchRemoteChain fetches the certificate chain of an endpoint using the configured options
+func fetchRemoteChain(address string) []*x509.Certificate {
+	chain, state, err := FetchRemoteChain(address, remoteTLSConfig(address), time.Duration(connectTimeout)*time.Second)
+	exitOnError(err, "Could not complete TLS handshake:", err)
+
+	log(fmt.Sprintf("Connected to %s (%s, %s)", address, tlsVersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)))
+	if state.NegotiatedProtocol != "" {
+		log("Negotiated protocol:", state.NegotiatedProtocol)
+	}
+	for i, c := range chain {
+		log(fmt.Sprintf("  %d: %s (issuer: %s, expires: %s)", i, c.Subject, c.Issuer, c.NotAfter.Format(time.RFC3339)))
+	}
+
+	return chain
+}
+
+// saveRemoteChain saves a presented certificate chain to the output directory
+func saveRemoteChain(name string, chain []*x509.Certificate) {
+	var data []byte
+	for _, c := range chain {
+		data = append(data, pemEncode("CERTIFICATE", c.Raw)...)
+	}
+
+	name = strings.NewReplacer(":", "_", "/", "_", "*", "_").Replace(name)
+	savePemFile(name+".remote.chain.pem", data)
+}
+
+// tlsVersionName returns a human readable TLS version
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04x", version)
+	}
+}
+
+// fetchCertificates defines the CLI command to save the certificate chain of a TLS endpoint
+func fetchCertificates(flags ...string) {
+	// Initialize command
+	cmd, args = newCommand(commandName("fetch"), "Save the certificate chain presented by a TLS endpoint", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&outputDirectory, "output", outputDirectory, "Path to directory to save files to")
+			remoteFlags(s)
+		})
+
+		h.AddArgument("HOST:PORT")
+
+		h.AddExample("Save the certificate chain of a website", "example.com:443")
+		h.AddExample("Use a client certificate for mutual TLS", "-clientCert client.cert.pem -clientKey client.key.pem internal.test:8443")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	arg := getArgument(true)
+
+	switch arg {
+	case "", "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&outputDirectory, "output")
+		chain := fetchRemoteChain(arg)
+		saveRemoteChain(remoteServerName(arg), chain)
+	}
+}
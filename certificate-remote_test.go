@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTLSServer starts a local TLS listener presenting a certificate issued by an authority.
+// The server names sent by clients are recorded.
+func testTLSServer(t *testing.T, cert string, key string, clientCAs *x509.CertPool) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var serverNames []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			serverNames = append(serverNames, hello.ServerName)
+			return &pair, nil
+		},
+	}
+	if clientCAs != nil {
+		server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		server.TLS.ClientCAs = clientCAs
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server, &serverNames
+}
+
+// testRemoteOptions sets the remote connection options and restores them after the test.
+func testRemoteOptions(t *testing.T, name string, protocols string, cert string, key string) {
+	t.Helper()
+
+	serverName, alpn, clientCert, clientCertKey = name, protocols, cert, key
+	t.Cleanup(func() {
+		serverName, alpn, clientCert, clientCertKey = "", "", "", ""
+	})
+}
+
+func TestFetchRemoteChain(t *testing.T) {
+	dir := t.TempDir()
+	_, rootCert, rootKey := testAuthority(t, dir, "remote-root")
+	cert, key := testIssue(t, dir, rootCert, rootKey, "service.test")
+	server, serverNames := testTLSServer(t, cert, key, nil)
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	testRemoteOptions(t, "service.test", "h2", "", "")
+	chain, state, err := FetchRemoteChain(address, remoteTLSConfig(address), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*serverNames) != 1 || (*serverNames)[0] != "service.test" {
+		t.Errorf("server names = %v, want [service.test]", *serverNames)
+	}
+	if state.NegotiatedProtocol != "h2" {
+		t.Errorf("negotiated protocol = %q, want h2", state.NegotiatedProtocol)
+	}
+
+	// The presented chain is verified against the acert root
+	a := Acert{
+		Certificate:     *chain[0],
+		RootCertificate: *parsePemCertificate(rootCert),
+		Hosts:           []string{"service.test"},
+	}
+	if err = a.Verify(); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+
+	// Other roots and host names are rejected
+	_, otherCert, _ := testAuthority(t, dir, "other-root")
+	a.RootCertificate = *parsePemCertificate(otherCert)
+	if err = a.Verify(); err == nil {
+		t.Error("Verify() succeeded with an unrelated root")
+	}
+	a.RootCertificate = *parsePemCertificate(rootCert)
+	a.Hosts = []string{"other.test"}
+	if err = a.Verify(); err == nil {
+		t.Error("Verify() succeeded with an unrelated host name")
+	}
+}
+
+func TestFetchRemoteChainClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	_, rootCert, rootKey := testAuthority(t, dir, "remote-root")
+	_, otherCert, otherKey := testAuthority(t, dir, "other-root")
+	cert, key := testIssue(t, dir, rootCert, rootKey, "service.test")
+	client, clientKey := testIssue(t, dir, rootCert, rootKey, "client.test")
+	other, otherClientKey := testIssue(t, t.TempDir(), otherCert, otherKey, "client.test")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(parsePemCertificate(rootCert))
+	server, _ := testTLSServer(t, cert, key, roots)
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	// TLS 1.3 servers reject client certificates after the handshake
+	for _, version := range []uint16{tls.VersionTLS13, tls.VersionTLS12} {
+		t.Run(tlsVersionName(version), func(t *testing.T) {
+			request := func() (tls.ConnectionState, error) {
+				config := remoteTLSConfig(address)
+				config.MaxVersion = version
+				_, state, err := FetchRemoteChain(address, config, 5*time.Second)
+				return state, err
+			}
+
+			testRemoteOptions(t, "service.test", "", "", "")
+			if _, err := request(); err == nil {
+				t.Error("connection succeeded without a client certificate")
+			}
+
+			testRemoteOptions(t, "service.test", "", other, otherClientKey)
+			if _, err := request(); err == nil {
+				t.Error("connection succeeded with a client certificate issued by another authority")
+			}
+
+			testRemoteOptions(t, "service.test", "", client, clientKey)
+			state, err := request()
+			if err != nil {
+				t.Errorf("connection with a client certificate failed: %v", err)
+			}
+			if state.Version != version {
+				t.Errorf("version = %s, want %s", tlsVersionName(state.Version), tlsVersionName(version))
+			}
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lstellway/go/command"
+)
+
+// Extension holding the reason a certificate was revoked
+// https://datatracker.ietf.org/doc/html/rfc5280#section-5.3.1
+var oidExtensionReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+// revocationReasons maps lower case reason names to RFC 5280 reason codes
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keycompromise":        1,
+	"cacompromise":         2,
+	"affiliationchanged":   3,
+	"superseded":           4,
+	"cessationofoperation": 5,
+	"certificatehold":      6,
+	"privilegewithdrawn":   9,
+	"aacompromise":         10,
+}
+
+// RevokedCertificate describes a revoked certificate
+type RevokedCertificate struct {
+	SerialNumber string    `json:"serialNumber"`
+	Subject      string    `json:"subject"`
+	RevokedAt    time.Time `json:"revokedAt"`
+	Reason       string    `json:"reason"`
+}
+
+// RevocationState holds the certificates revoked by an authority
+type RevocationState struct {
+	Revoked []RevokedCertificate `json:"revoked"`
+}
+
+// revocationStatePath returns the default revocation state file of an authority certificate.
+// The state is kept next to the certificate (eg, root.ca.cert.pem uses root.ca.revoked.json).
+func revocationStatePath(cert string) string {
+	for _, suffix := range []string{".cert.pem", ".fullchain.pem", ".pem", ".crt"} {
+		if strings.HasSuffix(cert, suffix) {
+			return strings.TrimSuffix(cert, suffix) + ".revoked.json"
+		}
+	}
+	return cert + ".revoked.json"
+}
+
+// readRevocationState reads a revocation state file.
+// A missing file is an empty state.
+func readRevocationState(file string) (RevocationState, error) {
+	var state RevocationState
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	return state, err
+}
+
+// Revoke adds a certificate to the revocation state
+func (s *RevocationState) Revoke(cert *x509.Certificate, reason string, at time.Time) error {
+	if _, ok := revocationReasons[strings.ToLower(reason)]; !ok {
+		return fmt.Errorf("unknown revocation reason '%s'", reason)
+	}
+
+	serial := fmt.Sprintf("%x", cert.SerialNumber)
+	for _, revoked := range s.Revoked {
+		if revoked.SerialNumber == serial {
+			return fmt.Errorf("certificate '%s' was already revoked at %s", cert.Subject, revoked.RevokedAt.Format(time.RFC3339))
+		}
+	}
+
+	s.Revoked = append(s.Revoked, RevokedCertificate{
+		SerialNumber: serial,
+		Subject:      cert.Subject.String(),
+		RevokedAt:    at.UTC(),
+		Reason:       reason,
+	})
+	return nil
+}
+
+// revokedCertificates converts the revocation state to CRL entries
+func (s *RevocationState) revokedCertificates() ([]pkix.RevokedCertificate, error) {
+	var entries []pkix.RevokedCertificate
+
+	for _, revoked := range s.Revoked {
+		serial, ok := new(big.Int).SetString(revoked.SerialNumber, 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid serial number '%s'", revoked.SerialNumber)
+		}
+
+		entry := pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: revoked.RevokedAt}
+		if code := revocationReasons[strings.ToLower(revoked.Reason)]; code != 0 {
+			value, err := asn1.Marshal(asn1.Enumerated(code))
+			if err != nil {
+				return nil, err
+			}
+			entry.Extensions = []pkix.Extension{{Id: oidExtensionReasonCode, Value: value}}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// CheckRevocation checks the certificates of a verified chain against the
+// revocation lists published at their CRL distribution points
+func CheckRevocation(chain []*x509.Certificate) error {
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+		if len(cert.CRLDistributionPoints) == 0 {
+			return fmt.Errorf("certificate '%s' does not include a CRL distribution point", cert.Subject)
+		}
+
+		crl, err := fetchRevocationList(cert.CRLDistributionPoints, issuer)
+		if err != nil {
+			return err
+		}
+
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("certificate '%s' was revoked at %s", cert.Subject, revoked.RevocationTime.Format(time.RFC3339))
+			}
+		}
+		log("Not revoked:", cert.Subject)
+	}
+
+	return nil
+}
+
+// fetchRevocationList downloads the first valid revocation list signed by the issuer
+func fetchRevocationList(urls []string, issuer *x509.Certificate) (*pkix.CertificateList, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	err := fmt.Errorf("no HTTP CRL distribution point")
+
+	for _, url := range urls {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			continue
+		}
+
+		var crl *pkix.CertificateList
+		if crl, err = fetchRevocationListURL(client, url); err != nil {
+			err = fmt.Errorf("%s: %v", url, err)
+			continue
+		}
+		if err = issuer.CheckCRLSignature(crl); err != nil {
+			err = fmt.Errorf("%s: revocation list is not signed by '%s': %v", url, issuer.Subject, err)
+			continue
+		}
+		if crl.HasExpired(time.Now()) {
+			err = fmt.Errorf("%s: revocation list expired at %s", url, crl.TBSCertList.NextUpdate.Format(time.RFC3339))
+			continue
+		}
+		return crl, nil
+	}
+
+	return nil, fmt.Errorf("could not fetch revocation list: %v", err)
+}
+
+// fetchRevocationListURL downloads and parses a revocation list
+func fetchRevocationListURL(client *http.Client, url string) (*pkix.CertificateList, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCRL(data)
+}
+
+// CertificateRepository publishes an authority certificate, its chain and
+// a certificate revocation list built from the revocation state.
+// The list is regenerated halfway through its validity period, well before
+// its next update, and whenever the revocation state file changes.
+type CertificateRepository struct {
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+	Signer      crypto.Signer
+	StateFile   string
+	CRLValidity time.Duration
+
+	mu        sync.Mutex
+	crl       []byte
+	number    *big.Int
+	refreshAt time.Time
+	stateTime time.Time
+}
+
+// RevocationList returns the current DER-encoded certificate revocation list
+func (r *CertificateRepository) RevocationList() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var stateTime time.Time
+	if info, err := os.Stat(r.StateFile); err == nil {
+		stateTime = info.ModTime()
+	}
+
+	now := time.Now()
+	if r.crl != nil && now.Before(r.refreshAt) && stateTime.Equal(r.stateTime) {
+		return r.crl, nil
+	}
+
+	state, err := readRevocationState(r.StateFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read revocation state: %v", err)
+	}
+	entries, err := state.revokedCertificates()
+	if err != nil {
+		return nil, err
+	}
+
+	// CRL numbers must increase, so they are based on the time of issue
+	number := big.NewInt(now.Unix())
+	if r.number != nil && number.Cmp(r.number) <= 0 {
+		number = new(big.Int).Add(r.number, big.NewInt(1))
+	}
+
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              number,
+		ThisUpdate:          now.Add(-defaultBackdate),
+		NextUpdate:          now.Add(r.CRLValidity),
+		RevokedCertificates: entries,
+	}, r.Certificate, r.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	log("Generated certificate revocation list", number, "with", len(entries), "revoked certificate(s)")
+	r.crl = crl
+	r.number = number
+	r.refreshAt = now.Add(r.CRLValidity / 2)
+	r.stateTime = stateTime
+	return crl, nil
+}
+
+// ServeHTTP serves the repository files at stable paths
+func (r *CertificateRepository) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	log(req.RemoteAddr, req.Method, req.URL.Path)
+
+	var (
+		data        []byte
+		contentType string
+		err         error
+	)
+
+	switch req.URL.Path {
+	case "/ca.crt", "/ca.cer", "/ca.der":
+		data, contentType = r.Certificate.Raw, "application/pkix-cert"
+	case "/ca.pem":
+		data, contentType = pemEncode("CERTIFICATE", r.Certificate.Raw), "application/x-pem-file"
+	case "/chain.pem":
+		data, contentType = pemEncode("CERTIFICATE", r.Certificate.Raw), "application/x-pem-file"
+		for _, c := range r.Chain {
+			data = append(data, pemEncode("CERTIFICATE", c.Raw)...)
+		}
+	case "/ca.crl":
+		contentType = "application/pkix-crl"
+		if data, err = r.RevocationList(); err != nil {
+			log("Could not generate certificate revocation list:", err)
+			http.Error(w, "could not generate certificate revocation list", http.StatusInternalServerError)
+			return
+		}
+	case "/":
+		data, contentType = []byte("ca.crt\nca.pem\nchain.pem\nca.crl\n"), "text/plain"
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+// repoCommand defines the CLI commands to publish and revoke certificates of an authority
+func repoCommand(flags ...string) {
+	// Initialize command
+	cmd, args = newCommand(commandName("repo"), "Publish an authority certificate and revocation list", func(h *command.Command) {
+		h.AddSubcommand("help", "Display this help screen")
+		h.AddSubcommand("serve", "Serve the authority certificate, chain and revocation list over HTTP")
+		h.AddSubcommand("revoke", "Revoke a certificate issued by the authority")
+	}, flags...)
+
+	switch getArgument(true) {
+	case "serve":
+		repoServe(args...)
+	case "revoke":
+		repoRevoke(args...)
+	default:
+		cmd.Usage()
+	}
+}
+
+// repoServe starts a repository server
+func repoServe(flags ...string) {
+	var listen, chain, state, crlValidity string
+
+	// Initialize command
+	cmd, args = newCommand(commandName("repo serve"), "Serve the authority certificate, chain and revocation list over HTTP", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&listen, "listen", "127.0.0.1:8080", "Address to listen on")
+			s.StringVar(&parent, "parent", "", "Path to PEM-encoded authority certificate")
+			s.StringVar(&key, "key", "", "Path to PEM-encoded private key of the authority certificate used to sign revocation lists")
+			s.StringVar(&chain, "chain", "", "Path to PEM-encoded certificates that complete the chain of the authority")
+			s.StringVar(&state, "state", "", "Path to the revocation state file (defaults to the authority name with a '.revoked.json' extension)")
+			s.StringVar(&crlValidity, "crlValidity", "24h", "Duration until the next update of the revocation list")
+		})
+
+		h.AddExample("Publish a local authority", "-parent local-root.ca.cert.pem -key local-root.ca.key.pem")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	switch getArgument(true) {
+	case "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&parent, "parent")
+		requireFileValue(&key, "key")
+		warnInsecureKeyPermissions(key)
+
+		cert, certs := readSignerChain(parent, chain)
+		parentKey := parsePemPrivateKey(key)
+		requireMatchingKey(cert, parentKey, key, parent)
+
+		validity := parseDurationValue(crlValidity, "crlValidity")
+		if validity == 0 {
+			exit(1, "The 'crlValidity' argument must be greater than 0")
+		}
+
+		signer, ok := parentKey.(crypto.Signer)
+		if !ok {
+			exit(1, "Private key cannot be used to sign revocation lists:", key)
+		}
+
+		repo := &CertificateRepository{
+			Certificate: cert,
+			Chain:       certs,
+			Signer:      signer,
+			StateFile:   defaultValue(state, revocationStatePath(parent)),
+			CRLValidity: validity,
+		}
+		_, err := repo.RevocationList()
+		exitOnError(err, "Could not generate certificate revocation list:", err)
+
+		log("Revocation state:", repo.StateFile)
+		log("Listening on http://" + listen)
+		for _, path := range []string{"ca.crt", "ca.pem", "chain.pem", "ca.crl"} {
+			log("    http://" + listen + "/" + path)
+		}
+		err = http.ListenAndServe(listen, repo)
+		exitOnError(err, "Could not start server:", err)
+	}
+}
+
+// repoRevoke revokes a certificate issued by an authority
+func repoRevoke(flags ...string) {
+	var state, reason string
+
+	reasons := make([]string, 0, len(revocationReasons))
+	for name := range revocationReasons {
+		reasons = append(reasons, name)
+	}
+	sort.Strings(reasons)
+
+	// Initialize command
+	cmd, args = newCommand(commandName("repo revoke"), "Revoke a certificate issued by the authority", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&parent, "parent", "", "Path to PEM-encoded authority certificate that issued the certificate")
+			s.StringVar(&state, "state", "", "Path to the revocation state file (defaults to the authority name with a '.revoked.json' extension)")
+			s.StringVar(&reason, "reason", "unspecified", fmt.Sprintf("Reason the certificate is revoked (%s)", strings.Join(reasons, ", ")))
+		})
+
+		h.AddArgument("CERTIFICATE_FILE")
+
+		h.AddExample("Revoke a compromised certificate", "-parent local-root.ca.cert.pem -reason keyCompromise test.com.cert.pem")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	arg := getArgument(true)
+
+	switch arg {
+	case "", "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&arg, "CERTIFICATE_FILE")
+		requireFileValue(&parent, "parent")
+
+		cert := parsePemCertificate(arg)
+		err := cert.CheckSignatureFrom(parsePemCertificate(parent))
+		exitOnError(err, "Certificate was not issued by the authority:", err)
+
+		state = defaultValue(state, revocationStatePath(parent))
+		revocations, err := readRevocationState(state)
+		exitOnError(err, "Could not read revocation state:", state, err)
+
+		err = revocations.Revoke(cert, reason, time.Now())
+		exitOnError(err, "Could not revoke certificate:", err)
+
+		data, err := json.MarshalIndent(revocations, "", "  ")
+		exitOnError(err, "Could not save revocation state:", err)
+		saveFile(state, append(data, '\n'), 0644, true)
+		log("Revoked certificate:", cert.Subject)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRepository creates a repository for an authority with a revocation state file in a directory
+func testRepository(t *testing.T, dir string, name string) (*CertificateRepository, string, string) {
+	t.Helper()
+
+	a, cert, key := testAuthority(t, dir, name)
+	repo := &CertificateRepository{
+		Certificate: testCertificate(t, cert),
+		Signer:      a.PrivateKey.(crypto.Signer),
+		StateFile:   revocationStatePath(cert),
+		CRLValidity: time.Hour,
+	}
+	return repo, cert, key
+}
+
+// testSaveRevocationState saves a revocation state file
+func testSaveRevocationState(t *testing.T, file string, state RevocationState) {
+	t.Helper()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testRevocationList parses the current revocation list of a repository and its CRL number
+func testRevocationList(t *testing.T, repo *CertificateRepository) (*pkix.TBSCertificateList, *big.Int) {
+	t.Helper()
+
+	data, err := repo.RevocationList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseCRL(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Certificate.CheckCRLSignature(crl); err != nil {
+		t.Fatal(err)
+	}
+
+	number := new(big.Int)
+	for _, extension := range crl.TBSCertList.Extensions {
+		if extension.Id.Equal(asn1.ObjectIdentifier{2, 5, 29, 20}) {
+			if _, err = asn1.Unmarshal(extension.Value, &number); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return &crl.TBSCertList, number
+}
+
+func TestRevocationStatePath(t *testing.T) {
+	tests := map[string]string{
+		"root.ca.cert.pem":      "root.ca.revoked.json",
+		"root.ca.fullchain.pem": "root.ca.revoked.json",
+		"root.pem":              "root.revoked.json",
+		"root.crt":              "root.revoked.json",
+		"root":                  "root.revoked.json",
+	}
+
+	for cert, want := range tests {
+		if got := revocationStatePath(cert); got != want {
+			t.Errorf("revocationStatePath(%q) = %q, want %q", cert, got, want)
+		}
+	}
+}
+
+func TestRevocationStateRevoke(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("EST", -5*3600))
+	first := &x509.Certificate{SerialNumber: big.NewInt(0xabc)}
+	second := &x509.Certificate{SerialNumber: big.NewInt(0xdef)}
+
+	var state RevocationState
+	if err := state.Revoke(first, "keyCompromise", at); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Revoke(first, "superseded", at); err == nil || !strings.Contains(err.Error(), "already revoked") {
+		t.Errorf("Revoke() = %v, want already revoked error", err)
+	}
+	if err := state.Revoke(second, "compromised", at); err == nil || !strings.Contains(err.Error(), "unknown revocation reason 'compromised'") {
+		t.Errorf("Revoke() = %v, want unknown reason error", err)
+	}
+
+	if len(state.Revoked) != 1 {
+		t.Fatalf("revoked = %+v, want a single certificate", state.Revoked)
+	}
+	revoked := state.Revoked[0]
+	if revoked.SerialNumber != "abc" || revoked.Reason != "keyCompromise" || !revoked.RevokedAt.Equal(at) || revoked.RevokedAt.Location() != time.UTC {
+		t.Errorf("revoked = %+v, want serial abc revoked for keyCompromise at %s in UTC", revoked, at)
+	}
+}
+
+func TestRevokedCertificates(t *testing.T) {
+	at := time.Now().UTC()
+	state := RevocationState{Revoked: []RevokedCertificate{
+		{SerialNumber: "1", RevokedAt: at, Reason: "unspecified"},
+		{SerialNumber: "2", RevokedAt: at, Reason: "keyCompromise"},
+		{SerialNumber: "3", RevokedAt: at, Reason: "CESSATIONOFOPERATION"},
+		{SerialNumber: "4", RevokedAt: at, Reason: "aACompromise"},
+	}}
+
+	entries, err := state.revokedCertificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unspecified reasons are omitted from CRL entries
+	// https://datatracker.ietf.org/doc/html/rfc5280#section-5.3.1
+	want := []int{-1, 1, 5, 10}
+	for i, entry := range entries {
+		if entry.SerialNumber.Int64() != int64(i+1) || !entry.RevocationTime.Equal(at) {
+			t.Errorf("entries[%d] = serial %d revoked at %s, want serial %d revoked at %s", i, entry.SerialNumber, entry.RevocationTime, i+1, at)
+		}
+
+		code := -1
+		for _, extension := range entry.Extensions {
+			var value asn1.Enumerated
+			if !extension.Id.Equal(oidExtensionReasonCode) {
+				continue
+			}
+			if _, err = asn1.Unmarshal(extension.Value, &value); err != nil {
+				t.Fatal(err)
+			}
+			code = int(value)
+		}
+		if code != want[i] {
+			t.Errorf("entries[%d] reason code = %d, want %d", i, code, want[i])
+		}
+	}
+
+	state.Revoked = append(state.Revoked, RevokedCertificate{SerialNumber: "xyz"})
+	if _, err = state.revokedCertificates(); err == nil || !strings.Contains(err.Error(), "invalid serial number 'xyz'") {
+		t.Errorf("revokedCertificates() = %v, want invalid serial number error", err)
+	}
+}
+
+func TestRevocationList(t *testing.T) {
+	dir := t.TempDir()
+	repo, _, _ := testRepository(t, dir, "repo-root")
+
+	// A missing state file is an empty revocation list
+	crl, number := testRevocationList(t, repo)
+	if number.Sign() <= 0 {
+		t.Errorf("CRL number = %d, want a positive number", number)
+	}
+	if len(crl.RevokedCertificates) != 0 {
+		t.Errorf("revoked certificates = %d, want 0", len(crl.RevokedCertificates))
+	}
+	if !crl.NextUpdate.After(time.Now().Add(59 * time.Minute)) {
+		t.Errorf("next update = %s, want the CRL validity from now", crl.NextUpdate)
+	}
+
+	// The list is cached until it needs to be refreshed
+	if _, cached := testRevocationList(t, repo); cached.Cmp(number) != 0 {
+		t.Errorf("cached CRL number = %d, want %d", cached, number)
+	}
+
+	// The list is regenerated when the state file changes
+	var state RevocationState
+	if err := state.Revoke(&x509.Certificate{SerialNumber: big.NewInt(42)}, "keyCompromise", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	testSaveRevocationState(t, repo.StateFile, state)
+	modified := time.Now().Add(time.Minute)
+	if err := os.Chtimes(repo.StateFile, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, changedNumber := testRevocationList(t, repo)
+	if changedNumber.Cmp(number) <= 0 {
+		t.Errorf("CRL number = %d after the state changed, want greater than %d", changedNumber, number)
+	}
+	if len(changed.RevokedCertificates) != 1 || changed.RevokedCertificates[0].SerialNumber.Int64() != 42 {
+		t.Errorf("revoked certificates = %+v, want serial 42", changed.RevokedCertificates)
+	}
+
+	// The list is regenerated after the refresh time
+	repo.refreshAt = time.Now().Add(-time.Second)
+	if _, refreshed := testRevocationList(t, repo); refreshed.Cmp(changedNumber) <= 0 {
+		t.Errorf("CRL number = %d after the refresh time, want greater than %d", refreshed, changedNumber)
+	}
+	if !repo.refreshAt.After(time.Now().Add(29 * time.Minute)) {
+		t.Errorf("refresh at = %s, want halfway through the CRL validity", repo.refreshAt)
+	}
+
+	// Invalid state files are reported
+	if err := os.WriteFile(repo.StateFile, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(repo.StateFile, modified.Add(time.Minute), modified.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.RevocationList(); err == nil || !strings.Contains(err.Error(), "could not read revocation state") {
+		t.Errorf("RevocationList() = %v, want revocation state error", err)
+	}
+}
+
+func TestCheckRevocation(t *testing.T) {
+	dir := t.TempDir()
+	repo, cert, key := testRepository(t, dir, "crl-root")
+	other, _, _ := testRepository(t, dir, "other-root")
+
+	mux := http.NewServeMux()
+	mux.Handle("/ca.crl", repo)
+	mux.HandleFunc("/other.crl", func(w http.ResponseWriter, r *http.Request) {
+		data, err := other.RevocationList()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	issue := func(host string, crl ...string) []*x509.Certificate {
+		a := &Acert{
+			Hosts:           []string{host},
+			RootCertificate: *parsePemCertificate(cert),
+			RootPrivateKey:  parsePemPrivateKey(key),
+			Options:         AcertOptions{Days: 1, Algorithm: "ecdsa-p256", CRLDistributionPoints: crl},
+		}
+		leaf, err := x509.ParseCertificate(a.BuildCertificate(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []*x509.Certificate{leaf, repo.Certificate}
+	}
+
+	valid := issue("valid.test", server.URL+"/ca.crl")
+	revoked := issue("revoked.test", server.URL+"/ca.crl")
+	var state RevocationState
+	if err := state.Revoke(revoked[0], "keyCompromise", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	testSaveRevocationState(t, repo.StateFile, state)
+
+	tests := []struct {
+		name  string
+		chain []*x509.Certificate
+		err   string
+	}{
+		{"not revoked", valid, ""},
+		{"authority only", valid[1:], ""},
+		{"revoked", revoked, "was revoked at"},
+		{"fallback distribution point", issue("fallback.test", "ldap://pki.test/ca.crl", server.URL+"/missing.crl", server.URL+"/ca.crl"), ""},
+		{"no distribution point", issue("none.test"), "does not include a CRL distribution point"},
+		{"no HTTP distribution point", issue("ldap.test", "ldap://pki.test/ca.crl"), "no HTTP CRL distribution point"},
+		{"missing revocation list", issue("missing.test", server.URL+"/missing.crl"), "unexpected status 404"},
+		{"revocation list of another authority", issue("other.test", server.URL+"/other.crl"), "revocation list is not signed by"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckRevocation(tt.chain)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("CheckRevocation() = %v, want nil", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("CheckRevocation() = %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
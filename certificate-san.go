@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+var (
+	// Subject alternative name extension
+	// https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.1.6
+	oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+	// Microsoft user principal name used for smart card logon
+	oidUserPrincipalName = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+)
+
+// GeneralName tags
+// https://datatracker.ietf.org/doc/html/rfc5280#appendix-A.2
+const (
+	nameTypeOther        = 0
+	nameTypeEmail        = 1
+	nameTypeDNS          = 2
+	nameTypeURI          = 6
+	nameTypeIP           = 7
+	nameTypeRegisteredID = 8
+)
+
+// subjectAlternativeNamePrefixes are the prefixes used to set the type of a subject alternative name
+var subjectAlternativeNamePrefixes = []string{"dns", "ip", "email", "uri", "upn", "rid"}
+
+// ParseSubjectAlternativeName returns the type and value of a subject alternative name.
+// The type is set with a prefix (eg, dns:, ip:, email:, uri:, upn: or rid:),
+// otherwise it is inferred from the value.
+func ParseSubjectAlternativeName(value string) (string, string, error) {
+	kind, name := "", strings.TrimSpace(value)
+	for _, prefix := range subjectAlternativeNamePrefixes {
+		if strings.HasPrefix(strings.ToLower(name), prefix+":") {
+			kind, name = prefix, strings.TrimSpace(name[len(prefix)+1:])
+			break
+		}
+	}
+
+	// IP addresses with a zone and URIs without a host (eg, spiffe:///path)
+	// are inferred so they are not reported as invalid DNS names
+	if kind == "" {
+		if ip := net.ParseIP(strings.SplitN(name, "%", 2)[0]); ip != nil {
+			kind = "ip"
+		} else if email, err := mail.ParseAddress(name); err == nil && email.Address == name {
+			kind = "email"
+		} else if uri, err := url.Parse(name); err == nil && uri.Scheme != "" && (uri.Host != "" || strings.Contains(name, "://")) {
+			kind = "uri"
+		} else {
+			kind = "dns"
+		}
+	}
+
+	if name == "" {
+		return kind, name, fmt.Errorf("empty %s subject alternative name", kind)
+	}
+
+	switch kind {
+	case "dns":
+		if strings.ContainsAny(name, " :/@") {
+			return kind, name, fmt.Errorf("invalid DNS name '%s'", name)
+		}
+	case "ip":
+		if strings.Contains(name, "%") {
+			return kind, name, fmt.Errorf("IP address zones cannot be included in certificates: '%s'", name)
+		}
+		if net.ParseIP(name) == nil {
+			return kind, name, fmt.Errorf("invalid IP address '%s'", name)
+		}
+	case "email":
+		if email, err := mail.ParseAddress(name); err != nil || email.Address != name {
+			return kind, name, fmt.Errorf("invalid email address '%s'", name)
+		}
+	case "uri":
+		if uri, err := url.Parse(name); err != nil || !uri.IsAbs() {
+			return kind, name, fmt.Errorf("invalid URI '%s', an absolute URI is required", name)
+		}
+	case "upn":
+		if !strings.Contains(name, "@") {
+			return kind, name, fmt.Errorf("invalid user principal name '%s', expected user@domain", name)
+		}
+	case "rid":
+		if _, err := parseObjectIdentifier(name); err != nil {
+			return kind, name, err
+		}
+	}
+
+	return kind, name, nil
+}
+
+// subjectAlternativeNameValue returns a subject alternative name without its type prefix
+func subjectAlternativeNameValue(value string) string {
+	_, name, _ := ParseSubjectAlternativeName(value)
+	return name
+}
+
+// parseSubjectAlternativeNameValues validates subject alternative names
+func parseSubjectAlternativeNameValues(values []string) error {
+	for _, value := range values {
+		if _, _, err := ParseSubjectAlternativeName(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generalName encodes a subject alternative name as an ASN.1 GeneralName
+func generalName(kind string, name string) (asn1.RawValue, error) {
+	switch kind {
+	case "dns":
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeDNS, Bytes: []byte(name)}, nil
+	case "email":
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeEmail, Bytes: []byte(name)}, nil
+	case "uri":
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeURI, Bytes: []byte(name)}, nil
+	case "ip":
+		ip := net.ParseIP(name)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeIP, Bytes: ip}, nil
+	case "upn":
+		// OtherName ::= SEQUENCE { type-id OBJECT IDENTIFIER, value [0] EXPLICIT ANY }
+		value, err := asn1.MarshalWithParams(name, "utf8,explicit,tag:0")
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		typeID, err := asn1.Marshal(oidUserPrincipalName)
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeOther, IsCompound: true, Bytes: append(typeID, value...)}, nil
+	case "rid":
+		oid, _ := parseObjectIdentifier(name)
+		der, err := asn1.Marshal(oid)
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		var raw asn1.RawValue
+		if _, err = asn1.Unmarshal(der, &raw); err != nil {
+			return asn1.RawValue{}, err
+		}
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeRegisteredID, Bytes: raw.Bytes}, nil
+	}
+
+	return asn1.RawValue{}, fmt.Errorf("unknown subject alternative name type '%s'", kind)
+}
+
+// subjectAlternativeNameExtension encodes the subject alternative name extension in the given order.
+// The extension must be critical when the subject is empty.
+func subjectAlternativeNameExtension(values []string, critical bool) (pkix.Extension, error) {
+	var names []asn1.RawValue
+	for _, value := range values {
+		kind, name, err := ParseSubjectAlternativeName(value)
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		n, err := generalName(kind, name)
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		names = append(names, n)
+	}
+
+	der, err := asn1.Marshal(names)
+	return pkix.Extension{Id: oidExtensionSubjectAltName, Critical: critical, Value: der}, err
+}
+
+// hasCustomSubjectAlternativeNames reports whether a subject alternative name extension
+// contains names that are not supported by x509 templates (other names and registered IDs)
+func hasCustomSubjectAlternativeNames(extension pkix.Extension) bool {
+	var names []asn1.RawValue
+	if _, err := asn1.Unmarshal(extension.Value, &names); err != nil {
+		return false
+	}
+	for _, n := range names {
+		if n.Class == asn1.ClassContextSpecific && (n.Tag == nameTypeOther || n.Tag == nameTypeRegisteredID) {
+			return true
+		}
+	}
+	return false
+}
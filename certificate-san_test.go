@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSubjectAlternativeName(t *testing.T) {
+	tests := []struct {
+		value string
+		kind  string
+		name  string
+		err   string
+	}{
+		// Inferred types
+		{"test.com", "dns", "test.com", ""},
+		{" *.test.com ", "dns", "*.test.com", ""},
+		{"10.0.0.1", "ip", "10.0.0.1", ""},
+		{"::1", "ip", "::1", ""},
+		{"jdoe@corp.test", "email", "jdoe@corp.test", ""},
+		{"https://test.com/path", "uri", "https://test.com/path", ""},
+		{"spiffe://example.org/ns/default/sa/web", "uri", "spiffe://example.org/ns/default/sa/web", ""},
+
+		// Host and port pairs are not names
+		{"test.com:443", "dns", "test.com:443", "invalid DNS name 'test.com:443'"},
+		{"localhost:8443", "dns", "localhost:8443", "invalid DNS name"},
+		{"[::1]:443", "dns", "[::1]:443", "invalid DNS name"},
+
+		// URIs without a host
+		{"spiffe:///ns/default", "uri", "spiffe:///ns/default", ""},
+		{"urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6", "dns", "urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6", "invalid DNS name"},
+		{"uri:urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6", "uri", "urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6", ""},
+
+		// IPv6 zones
+		{"fe80::1%eth0", "ip", "fe80::1%eth0", "IP address zones cannot be included in certificates"},
+		{"ip:fe80::1%eth0", "ip", "fe80::1%eth0", "IP address zones cannot be included in certificates"},
+
+		// Explicit prefixes
+		{"DNS:test.com", "dns", "test.com", ""},
+		{"dns: test.com", "dns", "test.com", ""},
+		{"dns:10.0.0.1", "dns", "10.0.0.1", ""},
+		{"IP:10.0.0.1", "ip", "10.0.0.1", ""},
+		{"ip:test.com", "ip", "test.com", "invalid IP address 'test.com'"},
+		{"email:jdoe@corp.test", "email", "jdoe@corp.test", ""},
+		{"email:John Doe <jdoe@corp.test>", "email", "John Doe <jdoe@corp.test>", "invalid email address"},
+		{"uri:/relative/path", "uri", "/relative/path", "an absolute URI is required"},
+		{"upn:jdoe@corp.test", "upn", "jdoe@corp.test", ""},
+		{"UPN:jdoe", "upn", "jdoe", "expected user@domain"},
+		{"rid:1.2.840.113549", "rid", "1.2.840.113549", ""},
+		{"rid:1", "rid", "1", "object identifier"},
+		{"dns:", "dns", "", "empty dns subject alternative name"},
+		{"", "dns", "", "empty dns subject alternative name"},
+		{"test .com", "dns", "test .com", "invalid DNS name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			kind, name, err := ParseSubjectAlternativeName(tt.value)
+			if kind != tt.kind || name != tt.name {
+				t.Errorf("ParseSubjectAlternativeName() = %s %q, want %s %q", kind, name, tt.kind, tt.name)
+			}
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("ParseSubjectAlternativeName() = %v, want nil", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("ParseSubjectAlternativeName() = %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestGeneralName(t *testing.T) {
+	t.Run("upn", func(t *testing.T) {
+		raw, err := generalName("upn", "jdoe@corp.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := asn1.Marshal(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// OtherName ::= SEQUENCE { type-id OBJECT IDENTIFIER, value [0] EXPLICIT ANY }
+		var other struct {
+			TypeID asn1.ObjectIdentifier
+			Value  string `asn1:"utf8,explicit,tag:0"`
+		}
+		rest, err := asn1.UnmarshalWithParams(der, &other, "tag:0")
+		if err != nil || len(rest) != 0 {
+			t.Fatalf("UnmarshalWithParams() = %v with %d trailing bytes", err, len(rest))
+		}
+		if !other.TypeID.Equal(oidUserPrincipalName) || other.Value != "jdoe@corp.test" {
+			t.Errorf("other name = %s %q, want %s %q", other.TypeID, other.Value, oidUserPrincipalName, "jdoe@corp.test")
+		}
+	})
+
+	t.Run("rid", func(t *testing.T) {
+		raw, err := generalName("rid", "1.2.840.113549.1.9.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := asn1.Marshal(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// registeredID [8] IMPLICIT OBJECT IDENTIFIER
+		var oid asn1.ObjectIdentifier
+		rest, err := asn1.UnmarshalWithParams(der, &oid, "tag:8")
+		if err != nil || len(rest) != 0 {
+			t.Fatalf("UnmarshalWithParams() = %v with %d trailing bytes", err, len(rest))
+		}
+		if want := (asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}); !oid.Equal(want) {
+			t.Errorf("registered ID = %s, want %s", oid, want)
+		}
+	})
+
+	if _, err := generalName("x400", "value"); err == nil {
+		t.Error("generalName() = nil, want error for an unknown type")
+	}
+}
+
+func TestSubjectAlternativeNameExtension(t *testing.T) {
+	values := []string{"upn:jdoe@corp.test", "test.com", "rid:1.2.3.4", "10.0.0.1", "jdoe@corp.test", "spiffe://example.org/web"}
+	extension, err := subjectAlternativeNameExtension(values, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !extension.Id.Equal(oidExtensionSubjectAltName) || !extension.Critical {
+		t.Errorf("extension = %s critical %v, want critical subject alternative name", extension.Id, extension.Critical)
+	}
+	if !hasCustomSubjectAlternativeNames(extension) {
+		t.Error("hasCustomSubjectAlternativeNames() = false, want true")
+	}
+
+	// Names keep their order
+	var names []asn1.RawValue
+	if _, err = asn1.Unmarshal(extension.Value, &names); err != nil {
+		t.Fatal(err)
+	}
+	var tags []int
+	for _, n := range names {
+		tags = append(tags, n.Tag)
+	}
+	if want := []int{nameTypeOther, nameTypeDNS, nameTypeRegisteredID, nameTypeIP, nameTypeEmail, nameTypeURI}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+	if ip := net.IP(names[3].Bytes); len(ip) != net.IPv4len || !ip.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("IP address = %v, want 4-byte 10.0.0.1", names[3].Bytes)
+	}
+
+	// Issued certificates keep the names supported by x509 alongside custom names
+	a := Acert{Hosts: values, Options: AcertOptions{Days: 1, Algorithm: "ecdsa-p256"}}
+	cert, err := x509.ParseCertificate(a.BuildCertificate(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cert.DNSNames, []string{"test.com"}) || len(cert.IPAddresses) != 1 ||
+		!reflect.DeepEqual(cert.EmailAddresses, []string{"jdoe@corp.test"}) || len(cert.URIs) != 1 {
+		t.Errorf("names = %v %v %v %v, want one name of each type", cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs)
+	}
+
+	standard, err := subjectAlternativeNameExtension([]string{"test.com", "10.0.0.1", "jdoe@corp.test"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasCustomSubjectAlternativeNames(standard) {
+		t.Error("hasCustomSubjectAlternativeNames() = true, want false for standard names")
+	}
+
+	if _, err = subjectAlternativeNameExtension([]string{"test.com", "ip:test.com"}, false); err == nil {
+		t.Error("subjectAlternativeNameExtension() = nil, want error for an invalid name")
+	}
+}
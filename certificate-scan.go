@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lstellway/go/command"
+)
+
+// Exit code used when scanned certificates are expired or expiring soon
+const scanExpiringExitCode = 2
+
+// Exit code used when files could not be scanned
+const scanErrorExitCode = 1
+
+// Files larger than this are not expected to contain PEM data and are skipped
+const scanMaxFileSize = 1 << 20
+
+// ScannedCertificate describes a certificate found while scanning.
+// Certificates are identified by fingerprint, so duplicates found
+// in multiple files (eg, chain files) are reported once.
+type ScannedCertificate struct {
+	Files         []string  `json:"files"`
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	Fingerprint   string    `json:"fingerprint"`
+	IsCA          bool      `json:"isCa"`
+	NotAfter      time.Time `json:"notAfter"`
+	DaysRemaining int       `json:"daysRemaining"`
+	Status        string    `json:"status"`
+	Keys          []string  `json:"keys"`
+	Chain         []string  `json:"chain"`
+
+	certificate *x509.Certificate
+}
+
+// ScannedKey describes a private key found while scanning
+type ScannedKey struct {
+	File         string   `json:"file"`
+	Algorithm    string   `json:"algorithm"`
+	Certificates []string `json:"certificates"`
+	Requests     []string `json:"requests"`
+	Orphan       bool     `json:"orphan"`
+
+	publicKey crypto.PublicKey
+}
+
+// ScannedRequest describes a certificate signing request found while scanning
+type ScannedRequest struct {
+	File    string   `json:"file"`
+	Subject string   `json:"subject"`
+	Keys    []string `json:"keys"`
+
+	request *x509.CertificateRequest
+}
+
+// ScanReport holds the results of scanning paths for PKI files
+type ScanReport struct {
+	Certificates []*ScannedCertificate `json:"certificates"`
+	Keys         []*ScannedKey         `json:"keys"`
+	Requests     []*ScannedRequest     `json:"requests"`
+	Errors       []string              `json:"errors"`
+
+	fingerprints map[string]*ScannedCertificate
+}
+
+// addPem adds PKI objects decoded from PEM data in a file to the report
+func (r *ScanReport) addPem(file string, data []byte) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", file, err))
+				continue
+			}
+			r.addCertificate(file, c)
+		case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+			request, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil {
+				r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", file, err))
+				continue
+			}
+			r.Requests = append(r.Requests, &ScannedRequest{File: file, Subject: request.Subject.String(), request: request})
+		case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+			key, err := parsePrivateKeyBlock(block)
+			if err != nil {
+				r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", file, err))
+				continue
+			}
+			r.Keys = append(r.Keys, &ScannedKey{File: file, Algorithm: keyAlgorithmName(key), publicKey: publicKey(key)})
+		}
+	}
+}
+
+// addCertificate adds a certificate to the report, merging duplicates
+func (r *ScanReport) addCertificate(file string, c *x509.Certificate) {
+	fingerprint := certificateFingerprint(c, crypto.SHA256)
+	if existing, ok := r.fingerprints[fingerprint]; ok {
+		existing.Files = append(existing.Files, file)
+		return
+	}
+
+	scanned := &ScannedCertificate{
+		Files:       []string{file},
+		Subject:     c.Subject.String(),
+		Issuer:      c.Issuer.String(),
+		Fingerprint: fingerprint,
+		IsCA:        c.IsCA,
+		NotAfter:    c.NotAfter,
+		certificate: c,
+	}
+	r.fingerprints[fingerprint] = scanned
+	r.Certificates = append(r.Certificates, scanned)
+}
+
+// link matches keys to certificates and requests, builds certificate chains
+// and computes the expiry status of each certificate.
+func (r *ScanReport) link(expiring time.Duration) {
+	for _, key := range r.Keys {
+		for _, c := range r.Certificates {
+			if publicKeysMatch(key.publicKey, c.certificate.PublicKey) {
+				key.Certificates = append(key.Certificates, c.Files[0])
+				c.Keys = append(c.Keys, key.File)
+			}
+		}
+		for _, request := range r.Requests {
+			if publicKeysMatch(key.publicKey, request.request.PublicKey) {
+				key.Requests = append(key.Requests, request.File)
+				request.Keys = append(request.Keys, key.File)
+			}
+		}
+		key.Orphan = len(key.Certificates) == 0 && len(key.Requests) == 0
+	}
+
+	for _, c := range r.Certificates {
+		remaining := c.NotAfter.Sub(now)
+		c.DaysRemaining = int(remaining.Hours() / 24)
+
+		switch {
+		case remaining <= 0:
+			c.Status = "expired"
+		case remaining <= expiring:
+			c.Status = "expiring"
+		default:
+			c.Status = "valid"
+		}
+
+		// Follow issuers found in the scanned files
+		current := c
+		seen := map[string]bool{c.Fingerprint: true}
+		for {
+			issuer := r.findIssuer(current)
+			if issuer == nil || seen[issuer.Fingerprint] {
+				break
+			}
+			seen[issuer.Fingerprint] = true
+			c.Chain = append(c.Chain, issuer.Subject)
+			current = issuer
+		}
+	}
+}
+
+// findIssuer returns the scanned certificate that signed a certificate.
+// Self-signed certificates do not have an issuer in the chain.
+func (r *ScanReport) findIssuer(c *ScannedCertificate) *ScannedCertificate {
+	for _, candidate := range r.Certificates {
+		if candidate == c || !bytes.Equal(c.certificate.RawIssuer, candidate.certificate.RawSubject) {
+			continue
+		}
+		if c.certificate.CheckSignatureFrom(candidate.certificate) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// ScanPaths walks files and directories, parsing PEM-encoded certificates, keys and requests
+func ScanPaths(paths []string, expiring time.Duration) *ScanReport {
+	report := &ScanReport{fingerprints: map[string]*ScannedCertificate{}}
+
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				return nil
+			}
+
+			// Skip version control and other hidden directories
+			if entry.IsDir() {
+				if file != root && strings.HasPrefix(entry.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || info.Size() > scanMaxFileSize {
+				return nil
+			}
+
+			data, err := os.ReadFile(file)
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				return nil
+			}
+			if bytes.Contains(data, []byte("-----BEGIN ")) {
+				report.addPem(file, data)
+			}
+			return nil
+		})
+		exitOnError(err, err)
+	}
+
+	report.link(expiring)
+
+	sort.SliceStable(report.Certificates, func(i, j int) bool {
+		return report.Certificates[i].NotAfter.Before(report.Certificates[j].NotAfter)
+	})
+
+	return report
+}
+
+// ExitCode returns the exit code of the scan command for a report.
+// Expiring certificates take precedence over errors, since they require action.
+func (r *ScanReport) ExitCode() int {
+	for _, c := range r.Certificates {
+		if c.Status != "valid" {
+			return scanExpiringExitCode
+		}
+	}
+	if len(r.Errors) > 0 {
+		return scanErrorExitCode
+	}
+	return 0
+}
+
+// printScanReport prints a scan report as tables
+func printScanReport(report *ScanReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "STATUS\tEXPIRES\tDAYS\tSUBJECT\tISSUER\tCHAIN ROOT\tKEY\tFILE")
+	for _, c := range report.Certificates {
+		key, root := "-", "-"
+		if len(c.Keys) > 0 {
+			key = c.Keys[0]
+		}
+		if len(c.Chain) > 0 {
+			root = c.Chain[len(c.Chain)-1]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", c.Status, c.NotAfter.Format("2006-01-02"), c.DaysRemaining, c.Subject, c.Issuer, root, key, c.Files[0])
+	}
+	w.Flush()
+
+	var orphans []string
+	for _, key := range report.Keys {
+		if key.Orphan {
+			orphans = append(orphans, key.File)
+		}
+	}
+	if len(orphans) > 0 {
+		log("\nPrivate keys without a matching certificate or request:")
+		for _, file := range orphans {
+			log("   ", file)
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		log("\nErrors:")
+		for _, message := range report.Errors {
+			log("   ", message)
+		}
+	}
+}
+
+// scanCertificates defines the CLI command to scan paths for PKI files
+func scanCertificates(flags ...string) {
+	var (
+		asJson bool
+		within int
+	)
+
+	// Initialize command
+	cmd, args = newCommand(commandName("scan"), "Scan directories for PKI certificates, keys and signing requests", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.BoolVar(&asJson, "json", false, "Output the inventory as JSON")
+			s.IntVar(&within, "within", 30, fmt.Sprintf("Exit with code %d if a certificate expires within this number of days", scanExpiringExitCode))
+		})
+
+		h.AddArgument("PATH...")
+
+		h.AddExample("Scan the current directory", ".")
+		h.AddExample("Fail a CI job if a certificate expires within 14 days", "-within 14 certs/ deploy/")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	switch getArgument(false) {
+	case "", "help":
+		cmd.Usage()
+	default:
+		report := ScanPaths(args, time.Hour*24*time.Duration(within))
+
+		if asJson {
+			data, err := json.MarshalIndent(report, "", "  ")
+			exitOnError(err, err)
+			log(string(data))
+		} else {
+			printScanReport(report)
+		}
+
+		if code := report.ExitCode(); code != 0 {
+			os.Exit(code)
+		}
+	}
+}
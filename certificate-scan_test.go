@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testScanFile writes PEM data to a file in a directory.
+func testScanFile(t *testing.T, dir string, name string, data []byte) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// testScanCertificate saves a self-signed certificate expiring after a duration, and its private key.
+func testScanCertificate(t *testing.T, dir string, name string, expires time.Duration) {
+	t.Helper()
+
+	a := &Acert{
+		Subject: pkix.Name{CommonName: name},
+		Options: AcertOptions{
+			Algorithm: "ecdsa-p256",
+			NotBefore: now.Add(expires - 365*24*time.Hour),
+			NotAfter:  now.Add(expires),
+		},
+	}
+	testScanFile(t, dir, name+".cert.pem", pemEncode("CERTIFICATE", a.BuildCertificate(false)))
+	testScanFile(t, dir, name+".key.pem", pemEncode("PRIVATE KEY", privateKeyPkcs(a.PrivateKey)))
+}
+
+func TestScanPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		expires  []time.Duration
+		invalid  bool
+		statuses []string
+		exitCode int
+	}{
+		{"valid", []time.Duration{90 * 24 * time.Hour}, false, []string{"valid"}, 0},
+		{"expiring", []time.Duration{90 * 24 * time.Hour, 5 * 24 * time.Hour}, false, []string{"expiring", "valid"}, scanExpiringExitCode},
+		{"expired", []time.Duration{-24 * time.Hour, 90 * 24 * time.Hour}, false, []string{"expired", "valid"}, scanExpiringExitCode},
+		{"errors", []time.Duration{90 * 24 * time.Hour}, true, []string{"valid"}, scanErrorExitCode},
+		{"expiring with errors", []time.Duration{5 * 24 * time.Hour}, true, []string{"expiring"}, scanExpiringExitCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for i, expires := range tt.expires {
+				testScanCertificate(t, filepath.Join(dir, "certs"), string(rune('a'+i)), expires)
+			}
+			if tt.invalid {
+				testScanFile(t, dir, "broken.pem", pemEncode("CERTIFICATE", []byte("invalid")))
+			}
+
+			report := ScanPaths([]string{dir}, 30*24*time.Hour)
+			if len(report.Certificates) != len(tt.statuses) {
+				t.Fatalf("certificates = %d, want %d", len(report.Certificates), len(tt.statuses))
+			}
+			for i, c := range report.Certificates {
+				if c.Status != tt.statuses[i] {
+					t.Errorf("%s status = %s, want %s", c.Subject, c.Status, tt.statuses[i])
+				}
+				if len(c.Keys) != 1 {
+					t.Errorf("%s keys = %v, want its private key", c.Subject, c.Keys)
+				}
+			}
+			if tt.invalid != (len(report.Errors) > 0) {
+				t.Errorf("errors = %v, want errors %v", report.Errors, tt.invalid)
+			}
+			if code := report.ExitCode(); code != tt.exitCode {
+				t.Errorf("ExitCode() = %d, want %d", code, tt.exitCode)
+			}
+		})
+	}
+}
+
+func TestScanPathsInventory(t *testing.T) {
+	dir := t.TempDir()
+	_, rootCert, rootKey := testAuthority(t, dir, "scan-root")
+	cert, _ := testIssue(t, dir, rootCert, rootKey, "scan.test")
+
+	// Signing request with its private key
+	request := &Acert{Subject: pkix.Name{CommonName: "request.test"}, Options: AcertOptions{Algorithm: "ed25519"}}
+	requestFile := testScanFile(t, dir, "request.csr.pem", pemEncode("CERTIFICATE REQUEST", request.BuildCertificateRequest()))
+	requestKey := testScanFile(t, dir, "request.key.pem", pemEncode("PRIVATE KEY", privateKeyPkcs(request.PrivateKey)))
+
+	// Private key without a certificate or request
+	orphan := &Acert{Options: AcertOptions{Algorithm: "ecdsa-p256"}}
+	orphan.requirePrivateKey()
+	orphanKey := testScanFile(t, dir, "orphan.key.pem", pemEncode("PRIVATE KEY", privateKeyPkcs(orphan.PrivateKey)))
+
+	// The same certificate in a chain file is reported once, and hidden directories are skipped
+	testScanFile(t, dir, "scan.test.fullchain.pem", append(readFile(cert), readFile(rootCert)...))
+	testScanFile(t, dir, ".git/hidden.cert.pem", readFile(rootCert))
+
+	report := ScanPaths([]string{dir, filepath.Join(dir, "missing")}, 7*24*time.Hour)
+	if len(report.Certificates) != 2 || len(report.Keys) != 4 || len(report.Requests) != 1 {
+		t.Fatalf("found %d certificates, %d keys and %d requests, want 2, 4 and 1", len(report.Certificates), len(report.Keys), len(report.Requests))
+	}
+
+	for _, c := range report.Certificates {
+		switch c.Subject {
+		case "CN=scan-root":
+			if len(c.Files) != 2 || len(c.Chain) != 0 {
+				t.Errorf("root files = %v and chain = %v, want 2 files without a chain", c.Files, c.Chain)
+			}
+		default:
+			if len(c.Files) != 2 || len(c.Chain) != 1 || c.Chain[0] != "CN=scan-root" || len(c.Keys) != 1 {
+				t.Errorf("leaf files = %v, chain = %v and keys = %v, want 2 files chained to CN=scan-root with a key", c.Files, c.Chain, c.Keys)
+			}
+		}
+	}
+
+	for _, key := range report.Keys {
+		if key.Orphan != (key.File == orphanKey) {
+			t.Errorf("%s orphan = %v", key.File, key.Orphan)
+		}
+		if key.File == requestKey && (len(key.Requests) != 1 || key.Requests[0] != requestFile || key.Algorithm == "") {
+			t.Errorf("request key = %+v, want the request %s", key, requestFile)
+		}
+	}
+	if len(report.Requests[0].Keys) != 1 || report.Requests[0].Keys[0] != requestKey {
+		t.Errorf("request keys = %v, want %s", report.Requests[0].Keys, requestKey)
+	}
+
+	// Missing paths are reported as errors
+	if len(report.Errors) != 1 || report.ExitCode() != scanErrorExitCode {
+		t.Errorf("errors = %v with exit code %d, want the missing path with exit code %d", report.Errors, report.ExitCode(), scanErrorExitCode)
+	}
+}
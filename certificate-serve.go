@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/lstellway/go/command"
+)
+
+// PeerCertificate describes a certificate presented during a TLS handshake
+type PeerCertificate struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serialNumber"`
+	Fingerprint  string    `json:"fingerprint"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	IsCA         bool      `json:"isCa"`
+	DNSNames     []string  `json:"dnsNames,omitempty"`
+	IPAddresses  []string  `json:"ipAddresses,omitempty"`
+	Emails       []string  `json:"emails,omitempty"`
+	URIs         []string  `json:"uris,omitempty"`
+}
+
+// ConnectionReport describes the negotiated parameters of a TLS connection
+type ConnectionReport struct {
+	RemoteAddress      string              `json:"remoteAddress"`
+	ServerName         string              `json:"serverName"`
+	Version            string              `json:"version"`
+	CipherSuite        string              `json:"cipherSuite"`
+	NegotiatedProtocol string              `json:"negotiatedProtocol"`
+	PeerCertificates   []PeerCertificate   `json:"peerCertificates"`
+	VerifiedChains     [][]PeerCertificate `json:"verifiedChains"`
+}
+
+// describePeerCertificates converts certificates to their reported details
+func describePeerCertificates(certs []*x509.Certificate) []PeerCertificate {
+	described := []PeerCertificate{}
+
+	for _, c := range certs {
+		p := PeerCertificate{
+			Subject:      c.Subject.String(),
+			Issuer:       c.Issuer.String(),
+			SerialNumber: c.SerialNumber.String(),
+			Fingerprint:  certificateFingerprint(c, crypto.SHA256),
+			NotBefore:    c.NotBefore,
+			NotAfter:     c.NotAfter,
+			IsCA:         c.IsCA,
+			DNSNames:     c.DNSNames,
+			Emails:       c.EmailAddresses,
+		}
+		for _, ip := range c.IPAddresses {
+			p.IPAddresses = append(p.IPAddresses, ip.String())
+		}
+		for _, uri := range c.URIs {
+			p.URIs = append(p.URIs, uri.String())
+		}
+		described = append(described, p)
+	}
+
+	return described
+}
+
+// NewConnectionReport builds a report from the state of a TLS connection
+func NewConnectionReport(remoteAddress string, state *tls.ConnectionState) ConnectionReport {
+	report := ConnectionReport{
+		RemoteAddress:      remoteAddress,
+		ServerName:         state.ServerName,
+		Version:            tlsVersionName(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		PeerCertificates:   describePeerCertificates(state.PeerCertificates),
+		VerifiedChains:     [][]PeerCertificate{},
+	}
+
+	for _, chain := range state.VerifiedChains {
+		report.VerifiedChains = append(report.VerifiedChains, describePeerCertificates(chain))
+	}
+
+	return report
+}
+
+// serveTLSConfig builds the server TLS configuration.
+// Client certificates are verified against the authority when one is set,
+// and are only mandatory when required.
+func serveTLSConfig(cert string, key string, ca string, requireClient bool) *tls.Config {
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	exitOnError(err, "Could not load server certificate:", err)
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		MinVersion:   tls.VersionTLS12,
+		ClientAuth:   tls.RequestClientCert,
+	}
+
+	if ca != "" {
+		config.ClientCAs = readCertPool(ca)
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if requireClient {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if requireClient {
+		config.ClientAuth = tls.RequireAnyClientCert
+	}
+
+	return config
+}
+
+// serveConnectionReport responds to requests with the connection report as JSON
+func serveConnectionReport(w http.ResponseWriter, r *http.Request) {
+	report := NewConnectionReport(r.RemoteAddr, r.TLS)
+	log(r.RemoteAddr, r.Method, r.URL.Path, report.Version, report.CipherSuite, len(report.PeerCertificates), "client certificate(s)")
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
+
+// serveCertificate defines the CLI command to serve a certificate over HTTPS
+func serveCertificate(flags ...string) {
+	var (
+		serveCert, serveKey, serveCa, listen string
+		requireClient                        bool
+	)
+
+	// Initialize command
+	cmd, args = newCommand(commandName("serve"), "Start a local HTTPS server to test a certificate", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&listen, "listen", "127.0.0.1:8443", "Address to listen on")
+			s.StringVar(&serveCert, "cert", "", "Path to PEM-encoded server certificate (may include the chain)")
+			s.StringVar(&serveKey, "key", "", "Path to PEM-encoded private key of the server certificate")
+			s.StringVar(&serveCa, "ca", "", "Path to PEM-encoded authority used to verify client certificates")
+			s.BoolVar(&requireClient, "requireClientCert", false, "Reject connections that do not present a client certificate")
+		})
+
+		h.AddExample("Serve a certificate", "-cert test.local.fullchain.pem -key test.local.key.pem")
+		h.AddExample("Require client certificates issued by an authority (mTLS)", "-cert test.local.fullchain.pem -key test.local.key.pem -ca local-root.ca.cert.pem -requireClientCert")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	switch getArgument(true) {
+	case "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&serveCert, "cert")
+		requireFileValue(&serveKey, "key")
+		warnInsecureKeyPermissions(serveKey)
+
+		server := &http.Server{
+			Addr:      listen,
+			Handler:   http.HandlerFunc(serveConnectionReport),
+			TLSConfig: serveTLSConfig(serveCert, serveKey, serveCa, requireClient),
+		}
+
+		log("Listening on https://" + listen)
+		err := server.ListenAndServeTLS("", "")
+		exitOnError(err, "Could not start server:", err)
+	}
+}
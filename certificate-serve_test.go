@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestServeConnectionReport(t *testing.T) {
+	dir := t.TempDir()
+	_, rootCert, rootKey := testAuthority(t, dir, "serve-root")
+	cert, key := testIssue(t, dir, rootCert, rootKey, "serve.test")
+	client, clientKey := testIssue(t, dir, rootCert, rootKey, "client.test")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(serveConnectionReport))
+	server.TLS = serveTLSConfig(cert, key, rootCert, true)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(parsePemCertificate(rootCert))
+	request := func(certificates ...tls.Certificate) (*ConnectionReport, error) {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "serve.test",
+			Certificates: certificates,
+		}}}
+		defer c.CloseIdleConnections()
+
+		response, err := c.Get(server.URL)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+
+		var report ConnectionReport
+		return &report, json.NewDecoder(response.Body).Decode(&report)
+	}
+
+	// Client certificates are required
+	if _, err := request(); err == nil {
+		t.Error("request succeeded without a client certificate")
+	}
+
+	pair, err := tls.LoadX509KeyPair(client, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := request(pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.ServerName != "serve.test" || report.Version == "" || report.CipherSuite == "" {
+		t.Errorf("report = %+v, want server name, version and cipher suite", report)
+	}
+	if len(report.PeerCertificates) != 1 || !reflect.DeepEqual(report.PeerCertificates[0].DNSNames, []string{"client.test"}) {
+		t.Errorf("peer certificates = %+v, want client.test", report.PeerCertificates)
+	}
+	if len(report.VerifiedChains) != 1 || len(report.VerifiedChains[0]) != 2 || report.VerifiedChains[0][1].Subject != "CN=serve-root" {
+		t.Errorf("verified chains = %+v, want client.test signed by serve-root", report.VerifiedChains)
+	}
+}
+
+func TestServeTLSConfigClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	_, rootCert, rootKey := testAuthority(t, dir, "serve-root")
+	cert, key := testIssue(t, dir, rootCert, rootKey, "serve.test")
+
+	tests := []struct {
+		ca            string
+		requireClient bool
+		want          tls.ClientAuthType
+	}{
+		{"", false, tls.RequestClientCert},
+		{"", true, tls.RequireAnyClientCert},
+		{rootCert, false, tls.VerifyClientCertIfGiven},
+		{rootCert, true, tls.RequireAndVerifyClientCert},
+	}
+
+	for _, tt := range tests {
+		config := serveTLSConfig(cert, key, tt.ca, tt.requireClient)
+		if config.ClientAuth != tt.want {
+			t.Errorf("serveTLSConfig(%q, %v).ClientAuth = %v, want %v", tt.ca, tt.requireClient, config.ClientAuth, tt.want)
+		}
+	}
+}
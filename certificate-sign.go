@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/digitorus/pkcs7"
+	"github.com/lstellway/go/command"
+)
+
+// Unsigned attribute holding an RFC 3161 time-stamp token for a signature
+// https://datatracker.ietf.org/doc/html/rfc3161#appendix-A
+var oidTimestampToken = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+
+// SignatureReport describes a verified detached signature
+type SignatureReport struct {
+	Signer      *x509.Certificate
+	SigningTime time.Time
+	Timestamp   time.Time
+}
+
+// readSignerChain reads a signing certificate and its chain.
+// The first certificate in the file is the signing certificate, and
+// any additional certificates (eg, a fullchain file) form the chain.
+func readSignerChain(cert string, chain string) (*x509.Certificate, []*x509.Certificate) {
+	certs, err := readPemCertificates(cert)
+	exitOnError(err, "Could not read signing certificate:", err)
+
+	if chain != "" {
+		parents, err := readPemCertificates(chain)
+		exitOnError(err, "Could not read certificate chain:", err)
+		certs = append(certs, parents...)
+	}
+
+	return certs[0], certs[1:]
+}
+
+// readCertPool reads every certificate in a PEM-encoded file into a certificate pool
+func readCertPool(file string) *x509.CertPool {
+	certs, err := readPemCertificates(file)
+	exitOnError(err, "Could not read certificates:", err)
+
+	pool := x509.NewCertPool()
+	for _, c := range certs {
+		pool.AddCert(c)
+	}
+	return pool
+}
+
+// SignDetached creates a detached CMS/PKCS #7 signature of data including the signer chain.
+// When a time-stamp authority URL is set, a time-stamp token for the signature is embedded.
+func SignDetached(data []byte, cert *x509.Certificate, chain []*x509.Certificate, key crypto.PrivateKey, tsaURL string) ([]byte, error) {
+	signed, err := pkcs7.NewSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+	signed.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+
+	if err = signed.AddSignerChain(cert, key, chain, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	signed.Detach()
+
+	if tsaURL != "" {
+		signer := &signed.GetSignedData().SignerInfos[0]
+		token, _, err := RequestTimestamp(tsaURL, signer.EncryptedDigest)
+		if err != nil {
+			return nil, fmt.Errorf("could not time-stamp signature: %v", err)
+		}
+
+		err = signer.SetUnauthenticatedAttributes([]pkcs7.Attribute{
+			{Type: oidTimestampToken, Value: asn1.RawValue{FullBytes: token}},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return signed.Finish()
+}
+
+// VerifyDetached verifies a detached CMS/PKCS #7 signature of data against trusted roots.
+// The signer certificate is checked at the time of an embedded time-stamp token,
+// otherwise at the current time.
+func VerifyDetached(signature []byte, data []byte, roots *x509.CertPool, usages []x509.ExtKeyUsage) (*SignatureReport, error) {
+	if block, _ := pem.Decode(signature); block != nil {
+		signature = block.Bytes
+	}
+
+	p7, err := pkcs7.Parse(signature)
+	if err != nil {
+		return nil, err
+	}
+	if len(p7.Signers) != 1 {
+		return nil, fmt.Errorf("expected a single signer, found %d", len(p7.Signers))
+	}
+	p7.Content = data
+
+	report := &SignatureReport{Signer: p7.GetOnlySigner()}
+	p7.UnmarshalSignedAttribute(pkcs7.OIDAttributeSigningTime, &report.SigningTime)
+
+	verifyAt := time.Now()
+	for _, attribute := range p7.Signers[0].UnauthenticatedAttributes {
+		if !attribute.Type.Equal(oidTimestampToken) {
+			continue
+		}
+
+		ts, err := VerifyTimestamp(attribute.Value.Bytes, p7.Signers[0].EncryptedDigest, roots)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time-stamp token: %v", err)
+		}
+		report.Timestamp = ts.Time
+		verifyAt = ts.Time
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range p7.Certificates {
+		intermediates.AddCert(c)
+	}
+	err = p7.VerifyWithOpts(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   verifyAt,
+		KeyUsages:     usages,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// signFile defines the CLI command to create a detached signature of a file
+func signFile(flags ...string) {
+	var signCert, signKey, signChain, signature, tsaURL string
+	var asPem bool
+
+	// Initialize command
+	cmd, args = newCommand(commandName("sign"), "Create a detached CMS/PKCS #7 signature of a file", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&signCert, "cert", "", "Path to PEM-encoded signing certificate (may include the chain)")
+			s.StringVar(&signKey, "key", "", "Path to PEM-encoded private key of the signing certificate")
+			s.StringVar(&signChain, "chain", "", "Path to PEM-encoded intermediate certificates to include in the signature")
+			s.StringVar(&signature, "signature", "", "Path to save the signature to (defaults to FILE.p7s)")
+			s.BoolVar(&asPem, "pem", false, "Save the signature PEM-encoded instead of DER-encoded")
+			s.StringVar(&tsaURL, "tsa", "", "URL of an RFC 3161 time-stamp authority used to time-stamp the signature")
+		})
+
+		h.AddArgument("FILE")
+
+		h.AddExample("Sign a release artefact", "-cert signer.fullchain.pem -key signer.key.pem release.tar.gz")
+		h.AddExample("Sign and time-stamp a release artefact", "-cert signer.fullchain.pem -key signer.key.pem -tsa http://127.0.0.1:3161 release.tar.gz")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	arg := getArgument(true)
+
+	switch arg {
+	case "", "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&arg, "FILE")
+		requireFileValue(&signCert, "cert")
+		requireFileValue(&signKey, "key")
+		warnInsecureKeyPermissions(signKey)
+
+		cert, chain := readSignerChain(signCert, signChain)
+		privateKey := parsePemPrivateKey(signKey)
+		requireMatchingKey(cert, privateKey, signKey, signCert)
+
+		data, err := SignDetached(readFile(arg), cert, chain, privateKey, tsaURL)
+		exitOnError(err, "Could not sign file:", err)
+
+		if asPem {
+			data = pemEncode("PKCS7", data)
+		}
+		saveFile(defaultValue(signature, arg+".p7s"), data, 0644, true)
+	}
+}
+
+// verifySignature defines the CLI command to verify a detached signature of a file
+func verifySignature(flags ...string) {
+	var signature string
+
+	// Initialize command
+	cmd, args = newCommand(commandName("verify-signature"), "Verify a detached CMS/PKCS #7 signature of a file", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&root, "root", "", "Trusted root certificate")
+			s.StringVar(&signature, "signature", "", "Path to the signature (defaults to FILE.p7s)")
+		})
+
+		h.AddArgument("FILE")
+
+		h.AddExample("Verify a signed release artefact", "-root root.ca.cert.pem release.tar.gz")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	arg := getArgument(true)
+
+	switch arg {
+	case "", "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&arg, "FILE")
+		requireFileValue(&root, "root")
+		signature = defaultValue(signature, arg+".p7s")
+		requireFileValue(&signature, "signature")
+
+		report, err := VerifyDetached(readFile(signature), readFile(arg), readCertPool(root), []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning})
+		exitOnError(err, "Signature could not be verified:", err)
+
+		log("Signer:", report.Signer.Subject)
+		if !report.SigningTime.IsZero() {
+			log("Signing time:", report.SigningTime.Format(time.RFC3339))
+		}
+		if !report.Timestamp.IsZero() {
+			log("Time-stamp:", report.Timestamp.Format(time.RFC3339))
+		}
+		log("Signature successfully verified")
+	}
+}
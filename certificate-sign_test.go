@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCodeSigningCertificate issues a code signing certificate from an authority
+func testCodeSigningCertificate(t *testing.T, authority *Acert) (*x509.Certificate, crypto.PrivateKey) {
+	t.Helper()
+
+	profile, err := lookupProfile("code-signing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := Acert{
+		Subject:         pkix.Name{CommonName: "Test Signer"},
+		RootCertificate: authority.RootCertificate,
+		RootPrivateKey:  authority.PrivateKey,
+		Options:         AcertOptions{Days: 1, Backdate: defaultBackdate, Algorithm: "ecdsa-p256"},
+	}
+	profile.Apply(&a.Options)
+
+	cert, err := x509.ParseCertificate(a.BuildCertificate(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, a.PrivateKey
+}
+
+func TestSignDetached(t *testing.T) {
+	server, root, roots := testTimestampAuthority(t)
+	cert, key := testCodeSigningCertificate(t, root)
+	data := []byte("release artifact")
+	usages := []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+
+	signature, err := SignDetached(data, cert, nil, key, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := VerifyDetached(signature, data, roots, usages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Signer.Equal(cert) {
+		t.Errorf("signer = %s, want %s", report.Signer.Subject, cert.Subject)
+	}
+	if report.Timestamp.IsZero() || report.SigningTime.IsZero() {
+		t.Errorf("report = %+v, want signing and time-stamp times", report)
+	}
+
+	// PEM-encoded signatures are accepted
+	if _, err = VerifyDetached(pemEncode("PKCS7", signature), data, roots, usages); err != nil {
+		t.Errorf("VerifyDetached() = %v, want nil for a PEM-encoded signature", err)
+	}
+
+	if _, err = VerifyDetached(signature, []byte("tampered artifact"), roots, usages); err == nil {
+		t.Error("VerifyDetached() = nil, want error for tampered content")
+	}
+	if _, err = VerifyDetached(signature, data, roots, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}); err == nil {
+		t.Error("VerifyDetached() = nil, want error for another key usage")
+	}
+
+	_, _, other := testTimestampAuthority(t)
+	if _, err = VerifyDetached(signature, data, other, usages); err == nil || !strings.Contains(err.Error(), "time-stamp") {
+		t.Errorf("VerifyDetached() = %v, want error for an untrusted time-stamp", err)
+	}
+
+	if _, err = SignDetached(data, cert, nil, key, "http://127.0.0.1:0"); err == nil || !strings.Contains(err.Error(), "could not time-stamp") {
+		t.Errorf("SignDetached() = %v, want error for an invalid time-stamp authority", err)
+	}
+}
+
+func TestVerifyDetachedAtTimestamp(t *testing.T) {
+	server, root, roots := testTimestampAuthority(t)
+	data := []byte("release artifact")
+	usages := []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+
+	// A signing certificate expiring shortly after signing
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Expiring Signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(2 * time.Second),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  usages,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, &root.RootCertificate, &key.PublicKey, root.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stamped, err := SignDetached(data, cert, nil, key, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unstamped, err := SignDetached(data, cert, nil, key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Until(cert.NotAfter) + time.Second)
+
+	// Time-stamped signatures remain valid after the signing certificate expires
+	report, err := VerifyDetached(stamped, data, roots, usages)
+	if err != nil {
+		t.Fatalf("VerifyDetached() = %v, want nil for a time-stamped signature", err)
+	}
+	if report.Timestamp.After(cert.NotAfter) {
+		t.Errorf("time-stamp = %v, want before %v", report.Timestamp, cert.NotAfter)
+	}
+
+	if _, err = VerifyDetached(unstamped, data, roots, usages); err == nil {
+		t.Error("VerifyDetached() = nil, want error for an expired signature without a time-stamp")
+	}
+	if _, err = VerifyDetached(stamped, []byte("tampered artifact"), roots, usages); err == nil {
+		t.Error("VerifyDetached() = nil, want error for tampered content")
+	}
+}
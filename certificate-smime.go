@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/digitorus/pkcs7"
+	"github.com/lstellway/go/command"
+)
+
+// The pkcs7 package selects the content encryption algorithm with a package variable
+// that defaults to DES-CBC. It is set once, as concurrent encryptions would race on it.
+func init() {
+	pkcs7.ContentEncryptionAlgorithm = pkcs7.EncryptionAlgorithmAES256CBC
+}
+
+// SmimeMessage is an RFC 5322 message split into the headers of the message
+// and the MIME entity (content headers and body) that is signed or encrypted.
+// Header fields are kept in their original order, including folded lines.
+type SmimeMessage struct {
+	Headers []string
+	Entity  []byte
+}
+
+// canonicalizeLineEndings converts line endings to CRLF as required by S/MIME
+func canonicalizeLineEndings(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+}
+
+// headerName returns the lower case name of a header field
+func headerName(field string) string {
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(field, ":", 2)[0]))
+}
+
+// headerValue returns the unfolded value of the first header field with a name
+func headerValue(fields []string, name string) string {
+	for _, field := range fields {
+		if headerName(field) == name {
+			value := strings.SplitN(field, ":", 2)[1]
+			return strings.TrimSpace(strings.NewReplacer("\r\n", "", "\n", "").Replace(value))
+		}
+	}
+	return ""
+}
+
+// ParseSmimeMessage parses an RFC 5322 message.
+// Content-* header fields belong to the MIME entity, while MIME-Version is dropped
+// as it is added when the message is rebuilt.
+// A plain text entity is built for messages without a Content-Type.
+func ParseSmimeMessage(data []byte) (*SmimeMessage, error) {
+	data = canonicalizeLineEndings(data)
+
+	separator := bytes.Index(data, []byte("\r\n\r\n"))
+	if separator < 0 {
+		return nil, fmt.Errorf("message does not contain a header section")
+	}
+	header, body := string(data[:separator]), data[separator+4:]
+
+	var fields []string
+	for _, line := range strings.Split(header, "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+		if !strings.Contains(line, ":") {
+			return nil, fmt.Errorf("invalid header field: %s", line)
+		}
+		fields = append(fields, line)
+	}
+
+	m := &SmimeMessage{}
+	var content []string
+	for _, field := range fields {
+		switch name := headerName(field); {
+		case name == "mime-version":
+		case strings.HasPrefix(name, "content-"):
+			content = append(content, field)
+		default:
+			m.Headers = append(m.Headers, field)
+		}
+	}
+
+	if len(content) == 0 {
+		content = []string{"Content-Type: text/plain; charset=utf-8"}
+		if isSevenBit(body) {
+			content = append(content, "Content-Transfer-Encoding: 7bit")
+		} else {
+			content = append(content, "Content-Transfer-Encoding: quoted-printable")
+			body = quotedPrintable(body)
+		}
+	}
+
+	m.Entity = append([]byte(strings.Join(content, "\r\n")+"\r\n\r\n"), body...)
+	return m, nil
+}
+
+// isSevenBit reports whether data only contains 7-bit characters
+func isSevenBit(data []byte) bool {
+	for _, b := range data {
+		if b > 127 {
+			return false
+		}
+	}
+	return true
+}
+
+// quotedPrintable encodes data using the quoted-printable encoding
+func quotedPrintable(data []byte) []byte {
+	var b bytes.Buffer
+	w := quotedprintable.NewWriter(&b)
+	w.Write(data)
+	w.Close()
+	return canonicalizeLineEndings(b.Bytes())
+}
+
+// base64Lines encodes data as base64 with lines of 76 characters
+func base64Lines(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var b bytes.Buffer
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return b.Bytes()
+}
+
+// build joins message headers with the headers and body of a new MIME entity
+func (m *SmimeMessage) build(content []string, body []byte) []byte {
+	var b bytes.Buffer
+	for _, field := range m.Headers {
+		b.WriteString(field + "\r\n")
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	for _, field := range content {
+		b.WriteString(field + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(body)
+	return b.Bytes()
+}
+
+// sender returns the address of the From header field
+func (m *SmimeMessage) sender() string {
+	address, err := mail.ParseAddress(headerValue(m.Headers, "from"))
+	if err != nil {
+		return ""
+	}
+	return address.Address
+}
+
+// SmimeSign builds a clear-signed (multipart/signed) message.
+// https://datatracker.ietf.org/doc/html/rfc8551#section-3.5
+func SmimeSign(m *SmimeMessage, cert *x509.Certificate, chain []*x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	signature, err := SignDetached(m.Entity, cert, chain, key, "")
+	if err != nil {
+		return nil, err
+	}
+
+	boundary, err := randomBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	body.WriteString("This is a cryptographically signed message in MIME format.\r\n\r\n")
+	body.WriteString("--" + boundary + "\r\n")
+	body.Write(m.Entity)
+	body.WriteString("\r\n--" + boundary + "\r\n")
+	body.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	body.WriteString("Content-Transfer-Encoding: base64\r\n")
+	body.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	body.Write(base64Lines(signature))
+	body.WriteString("--" + boundary + "--\r\n")
+
+	return m.build([]string{
+		fmt.Sprintf("Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256; boundary=\"%s\"", boundary),
+	}, body.Bytes()), nil
+}
+
+// SmimeVerify verifies a clear-signed message against trusted roots.
+// The signer certificate must be valid for email protection and
+// include the address of the message sender.
+func SmimeVerify(m *SmimeMessage, roots *x509.CertPool) (*SignatureReport, []byte, error) {
+	entity, signature, err := splitSignedEntity(m.Entity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report, err := VerifyDetached(signature, entity, roots, []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sender := m.sender()
+	matched := false
+	for _, address := range report.Signer.EmailAddresses {
+		matched = matched || strings.EqualFold(address, sender)
+	}
+	if !matched {
+		return nil, nil, fmt.Errorf("signer certificate does not include the sender address '%s'", sender)
+	}
+
+	return report, entity, nil
+}
+
+// splitSignedEntity returns the signed entity and decoded signature of a multipart/signed entity.
+// The signed entity is taken verbatim, as any change would invalidate the signature.
+func splitSignedEntity(entity []byte) ([]byte, []byte, error) {
+	headers, body := splitEntity(entity)
+
+	mediaType, params, err := mime.ParseMediaType(headerValue(headers, "content-type"))
+	if err != nil || mediaType != "multipart/signed" || params["boundary"] == "" {
+		return nil, nil, fmt.Errorf("message is not an S/MIME signed message")
+	}
+
+	delimiter := []byte("\r\n--" + params["boundary"])
+	body = append([]byte("\r\n"), body...)
+
+	var parts [][]byte
+	for {
+		start := bytes.Index(body, delimiter)
+		if start < 0 {
+			break
+		}
+		body = body[start+len(delimiter):]
+		if bytes.HasPrefix(body, []byte("--")) {
+			break
+		}
+		end := bytes.Index(body, delimiter)
+		if end < 0 {
+			return nil, nil, fmt.Errorf("signed message is missing a closing boundary")
+		}
+
+		// Skip the remainder of the delimiter line
+		part := body[:end]
+		if i := bytes.Index(part, []byte("\r\n")); i >= 0 {
+			part = part[i+2:]
+		}
+		parts = append(parts, part)
+	}
+
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("signed message must contain exactly 2 parts, found %d", len(parts))
+	}
+
+	signatureHeaders, signatureBody := splitEntity(parts[1])
+	if mediaType, _, _ := mime.ParseMediaType(headerValue(signatureHeaders, "content-type")); !strings.HasSuffix(mediaType, "pkcs7-signature") {
+		return nil, nil, fmt.Errorf("signed message does not contain a PKCS #7 signature")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(signatureBody), nil)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not decode signature: %v", err)
+	}
+
+	return parts[0], signature, nil
+}
+
+// splitEntity splits a MIME entity into header fields and body
+func splitEntity(entity []byte) ([]string, []byte) {
+	separator := bytes.Index(entity, []byte("\r\n\r\n"))
+	if separator < 0 {
+		return nil, entity
+	}
+
+	var fields []string
+	for _, line := range strings.Split(string(entity[:separator]), "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+		fields = append(fields, line)
+	}
+
+	return fields, entity[separator+4:]
+}
+
+// SmimeEncrypt builds an enveloped (encrypted) message for recipients using AES-256-CBC.
+// Key transport is only supported for RSA recipient certificates.
+// https://datatracker.ietf.org/doc/html/rfc8551#section-3.3
+func SmimeEncrypt(m *SmimeMessage, recipients []*x509.Certificate) ([]byte, error) {
+	for _, recipient := range recipients {
+		if _, ok := recipient.PublicKey.(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("recipient '%s' does not have an RSA key, which is required for encryption", recipient.Subject)
+		}
+	}
+
+	encrypted, err := pkcs7.Encrypt(m.Entity, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.build([]string{
+		"Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"",
+		"Content-Transfer-Encoding: base64",
+		"Content-Disposition: attachment; filename=\"smime.p7m\"",
+	}, base64Lines(encrypted)), nil
+}
+
+// SmimeDecrypt decrypts an enveloped message, returning the message with the decrypted entity
+func SmimeDecrypt(m *SmimeMessage, cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	headers, body := splitEntity(m.Entity)
+
+	mediaType, _, err := mime.ParseMediaType(headerValue(headers, "content-type"))
+	if err != nil || (mediaType != "application/pkcs7-mime" && mediaType != "application/x-pkcs7-mime") {
+		return nil, fmt.Errorf("message is not an S/MIME encrypted message")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(body), nil)))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode message: %v", err)
+	}
+
+	p7, err := pkcs7.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	entity, err := p7.Decrypt(cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	content, entityBody := splitEntity(canonicalizeLineEndings(entity))
+	return m.build(content, entityBody), nil
+}
+
+// randomBoundary returns a random multipart boundary
+func randomBoundary() (string, error) {
+	b := make([]byte, 15)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("acert-%x", b), nil
+}
+
+// readSmimeMessage reads and parses a message file
+func readSmimeMessage(file string) *SmimeMessage {
+	m, err := ParseSmimeMessage(readFile(file))
+	exitOnError(err, "Could not parse message:", file, err)
+	return m
+}
+
+// smimeCommand defines the CLI commands to sign, verify, encrypt and decrypt S/MIME messages
+func smimeCommand(flags ...string) {
+	// Initialize command
+	cmd, args = newCommand(commandName("smime"), "Sign, verify, encrypt and decrypt S/MIME email messages", func(h *command.Command) {
+		h.AddSubcommand("help", "Display this help screen")
+		h.AddSubcommand("sign", "Sign an email message")
+		h.AddSubcommand("verify", "Verify a signed email message")
+		h.AddSubcommand("encrypt", "Encrypt an email message for recipients")
+		h.AddSubcommand("decrypt", "Decrypt an email message")
+	}, flags...)
+
+	switch getArgument(true) {
+	case "sign":
+		smimeSign(args...)
+	case "verify":
+		smimeVerify(args...)
+	case "encrypt":
+		smimeEncrypt(args...)
+	case "decrypt":
+		smimeDecrypt(args...)
+	default:
+		cmd.Usage()
+	}
+}
+
+// smimeSign signs an email message
+func smimeSign(flags ...string) {
+	var signCert, signKey, signChain, out string
+
+	// Initialize command
+	cmd, args = newCommand(commandName("smime sign"), "Sign an email message", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&signCert, "cert", "", "Path to PEM-encoded S/MIME certificate of the sender (may include the chain)")
+			s.StringVar(&signKey, "key", "", "Path to PEM-encoded private key of the certificate")
+			s.StringVar(&signChain, "chain", "", "Path to PEM-encoded intermediate certificates to include in the signature")
+			s.StringVar(&out, "out", "", "Path to save the signed message to (defaults to MESSAGE.signed.eml)")
+		})
+
+		h.AddArgument("MESSAGE")
+
+		h.AddExample("Sign a message", "-cert alice@test.com.fullchain.pem -key alice@test.com.key.pem message.eml")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	arg := getArgument(true)
+
+	switch arg {
+	case "", "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&arg, "MESSAGE")
+		requireFileValue(&signCert, "cert")
+		requireFileValue(&signKey, "key")
+		warnInsecureKeyPermissions(signKey)
+
+		cert, chain := readSignerChain(signCert, signChain)
+		privateKey := parsePemPrivateKey(signKey)
+		requireMatchingKey(cert, privateKey, signKey, signCert)
+
+		message, err := SmimeSign(readSmimeMessage(arg), cert, chain, privateKey)
+		exitOnError(err, "Could not sign message:", err)
+		saveFile(defaultValue(out, arg+".signed.eml"), message, 0644, true)
+	}
+}
+
+// smimeVerify verifies a signed email message
+func smimeVerify(flags ...string) {
+	var out string
+
+	// Initialize command
+	cmd, args = newCommand(commandName("smime verify"), "Verify a signed email message", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&root, "root", "", "Trusted root certificate")
+			s.StringVar(&out, "out", "", "Path to save the verified message content to")
+		})
+
+		h.AddArgument("MESSAGE")
+
+		h.AddExample("Verify a signed message", "-root root.ca.cert.pem message.eml.signed.eml")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	arg := getArgument(true)
+
+	switch arg {
+	case "", "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&arg, "MESSAGE")
+		requireFileValue(&root, "root")
+
+		m := readSmimeMessage(arg)
+		report, entity, err := SmimeVerify(m, readCertPool(root))
+		exitOnError(err, "Message could not be verified:", err)
+
+		log("Signer:", report.Signer.Subject)
+		log("Sender:", m.sender())
+		if !report.SigningTime.IsZero() {
+			log("Signing time:", report.SigningTime.Format(time.RFC3339))
+		}
+		if out != "" {
+			content, body := splitEntity(entity)
+			saveFile(out, m.build(content, body), 0644, true)
+		}
+		log("Message successfully verified")
+	}
+}
+
+// smimeEncrypt encrypts an email message for recipients
+func smimeEncrypt(flags ...string) {
+	var recipients, out string
+
+	// Initialize command
+	cmd, args = newCommand(commandName("smime encrypt"), "Encrypt an email message for recipients", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&recipients, "recipient", "", "Comma-delimited paths to PEM-encoded S/MIME certificates of the recipients (RSA keys only)")
+			s.StringVar(&out, "out", "", "Path to save the encrypted message to (defaults to MESSAGE.encrypted.eml)")
+		})
+
+		h.AddArgument("MESSAGE")
+
+		h.AddExample("Encrypt a message for a recipient", "-recipient bob@test.com.cert.pem message.eml")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	arg := getArgument(true)
+
+	switch arg {
+	case "", "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&arg, "MESSAGE")
+
+		var certs []*x509.Certificate
+		for _, file := range splitValue(recipients, ",") {
+			requireFileValue(&file, "recipient")
+			certs = append(certs, parsePemCertificate(file))
+		}
+		if len(certs) == 0 {
+			exit(1, "At least one 'recipient' certificate is required")
+		}
+
+		message, err := SmimeEncrypt(readSmimeMessage(arg), certs)
+		exitOnError(err, "Could not encrypt message:", err)
+		saveFile(defaultValue(out, arg+".encrypted.eml"), message, 0644, true)
+	}
+}
+
+// smimeDecrypt decrypts an email message
+func smimeDecrypt(flags ...string) {
+	var recipientCert, recipientKey, out string
+
+	// Initialize command
+	cmd, args = newCommand(commandName("smime decrypt"), "Decrypt an email message", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&recipientCert, "cert", "", "Path to PEM-encoded S/MIME certificate of the recipient")
+			s.StringVar(&recipientKey, "key", "", "Path to PEM-encoded private key of the certificate")
+			s.StringVar(&out, "out", "", "Path to save the decrypted message to (defaults to MESSAGE.decrypted.eml)")
+		})
+
+		h.AddArgument("MESSAGE")
+
+		h.AddExample("Decrypt a message", "-cert bob@test.com.cert.pem -key bob@test.com.key.pem message.eml.encrypted.eml")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	arg := getArgument(true)
+
+	switch arg {
+	case "", "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&arg, "MESSAGE")
+		requireFileValue(&recipientCert, "cert")
+		requireFileValue(&recipientKey, "key")
+		warnInsecureKeyPermissions(recipientKey)
+
+		cert := parsePemCertificate(recipientCert)
+		privateKey := parsePemPrivateKey(recipientKey)
+		requireMatchingKey(cert, privateKey, recipientKey, recipientCert)
+
+		message, err := SmimeDecrypt(readSmimeMessage(arg), cert, privateKey)
+		exitOnError(err, "Could not decrypt message:", err)
+		saveFile(defaultValue(out, arg+".decrypted.eml"), message, 0644, true)
+	}
+}
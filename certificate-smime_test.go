@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// testSmimeCertificate issues an S/MIME certificate for an address signed by an authority
+func testSmimeCertificate(t *testing.T, authorityCert string, authorityKey string, address string, algorithm string) (*x509.Certificate, crypto.PrivateKey) {
+	t.Helper()
+
+	profile, err := lookupProfile("smime")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Acert{
+		Hosts:           []string{address},
+		RootCertificate: *parsePemCertificate(authorityCert),
+		RootPrivateKey:  parsePemPrivateKey(authorityKey),
+		Options:         AcertOptions{Days: 30, Algorithm: algorithm, Bits: 2048},
+	}
+	profile.Apply(&a.Options)
+
+	cert, err := x509.ParseCertificate(a.BuildCertificate(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, a.PrivateKey
+}
+
+// testSmimeMessage parses a message with the given sender
+func testSmimeMessage(t *testing.T, from string) *SmimeMessage {
+	t.Helper()
+
+	m, err := ParseSmimeMessage([]byte("From: " + from + "\nTo: bob@test.com\nSubject: Release\n\nThe release is ready.\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestParseSmimeMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		headers []string
+		entity  string
+		err     string
+	}{
+		{
+			name:    "line endings are canonicalized",
+			message: "From: alice@test.com\nSubject: Hi\n\nHello\nWorld\n",
+			headers: []string{"From: alice@test.com", "Subject: Hi"},
+			entity:  "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 7bit\r\n\r\nHello\r\nWorld\r\n",
+		},
+		{
+			name:    "folded header fields are kept",
+			message: "From: alice@test.com\r\nSubject: A long\r\n\tsubject\r\nTo: bob@test.com\r\n\r\nHello\r\n",
+			headers: []string{"From: alice@test.com", "Subject: A long\r\n\tsubject", "To: bob@test.com"},
+			entity:  "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 7bit\r\n\r\nHello\r\n",
+		},
+		{
+			name:    "content header fields belong to the entity",
+			message: "MIME-Version: 1.0\nFrom: alice@test.com\nContent-Type: text/html;\n charset=utf-8\nContent-Transfer-Encoding: base64\n\nPGI+SGk8L2I+\n",
+			headers: []string{"From: alice@test.com"},
+			entity:  "Content-Type: text/html;\r\n charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\nPGI+SGk8L2I+\r\n",
+		},
+		{
+			name:    "8-bit text is quoted-printable encoded",
+			message: "From: alice@test.com\n\nCafé\n",
+			headers: []string{"From: alice@test.com"},
+			entity:  "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nCaf=C3=A9\r\n",
+		},
+		{
+			name:    "missing header section",
+			message: "From: alice@test.com\n",
+			err:     "does not contain a header section",
+		},
+		{
+			name:    "invalid header field",
+			message: "From: alice@test.com\nnot a header\n\nHello\n",
+			err:     "invalid header field: not a header",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseSmimeMessage([]byte(tt.message))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParseSmimeMessage() = %v, want error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Join(m.Headers, "\n") != strings.Join(tt.headers, "\n") {
+				t.Errorf("headers = %q, want %q", m.Headers, tt.headers)
+			}
+			if string(m.Entity) != tt.entity {
+				t.Errorf("entity = %q, want %q", m.Entity, tt.entity)
+			}
+		})
+	}
+}
+
+func TestSplitSignedEntity(t *testing.T) {
+	signed := "Content-Type: text/plain\r\n\r\nSigned\r\n--not-the-boundary\r\n"
+	signature := base64.StdEncoding.EncodeToString([]byte("signature"))
+	part := func(contentType string, body string) string {
+		return "--b1\r\nContent-Type: " + contentType + "\r\n\r\n" + body + "\r\n"
+	}
+	header := "Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; boundary=\"b1\"\r\n\r\n"
+
+	tests := []struct {
+		name   string
+		entity string
+		err    string
+	}{
+		{"signed message", header + "Preamble\r\n--b1\r\n" + signed + "\r\n" + part("application/pkcs7-signature", signature[:8]+"\r\n"+signature[8:]) + "--b1--\r\n", ""},
+		{"legacy signature type", header + "--b1\r\n" + signed + "\r\n" + part("application/x-pkcs7-signature", signature) + "--b1--\r\n", ""},
+		{"not signed", "Content-Type: text/plain\r\n\r\nHello\r\n", "not an S/MIME signed message"},
+		{"missing boundary", "Content-Type: multipart/signed\r\n\r\nHello\r\n", "not an S/MIME signed message"},
+		{"missing closing boundary", header + "--b1\r\n" + signed, "missing a closing boundary"},
+		{"single part", header + "--b1\r\n" + signed + "\r\n--b1--\r\n", "exactly 2 parts, found 1"},
+		{"three parts", header + "--b1\r\n" + signed + "\r\n" + part("text/plain", "x") + part("application/pkcs7-signature", signature) + "--b1--\r\n", "exactly 2 parts, found 3"},
+		{"not a signature", header + "--b1\r\n" + signed + "\r\n" + part("text/plain", signature) + "--b1--\r\n", "does not contain a PKCS #7 signature"},
+		{"invalid signature encoding", header + "--b1\r\n" + signed + "\r\n" + part("application/pkcs7-signature", "!!!") + "--b1--\r\n", "could not decode signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entity, decoded, err := splitSignedEntity([]byte(tt.entity))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("splitSignedEntity() = %v, want error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The signed entity is returned verbatim, without the CRLF preceding the boundary
+			if string(entity) != signed {
+				t.Errorf("signed entity = %q, want %q", entity, signed)
+			}
+			if string(decoded) != "signature" {
+				t.Errorf("signature = %q, want %q", decoded, "signature")
+			}
+		})
+	}
+}
+
+func TestSmimeSignVerify(t *testing.T) {
+	dir := t.TempDir()
+	_, rootCert, rootKey := testAuthority(t, dir, "smime-root")
+	_, otherCert, _ := testAuthority(t, dir, "other-root")
+	cert, key := testSmimeCertificate(t, rootCert, rootKey, "alice@test.com", "ecdsa-p256")
+	roots := readCertPool(rootCert)
+
+	m := testSmimeMessage(t, "Alice <Alice@test.com>")
+	signed, err := SmimeSign(m, cert, nil, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseSmimeMessage(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Message headers are kept and the original entity is signed
+	if strings.Join(parsed.Headers, "\n") != strings.Join(m.Headers, "\n") {
+		t.Errorf("headers = %q, want %q", parsed.Headers, m.Headers)
+	}
+	report, entity, err := SmimeVerify(parsed, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(entity, m.Entity) {
+		t.Errorf("signed entity = %q, want %q", entity, m.Entity)
+	}
+	if report.Signer.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Errorf("signer = %s, want %s", report.Signer.Subject, cert.Subject)
+	}
+
+	t.Run("tampered content", func(t *testing.T) {
+		tampered, err := ParseSmimeMessage(bytes.Replace(signed, []byte("The release is ready."), []byte("The release is broken"), 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = SmimeVerify(tampered, roots); err == nil {
+			t.Error("SmimeVerify() = nil, want error for tampered content")
+		}
+	})
+
+	t.Run("sender mismatch", func(t *testing.T) {
+		spoofed, err := ParseSmimeMessage(bytes.Replace(signed, []byte("From: Alice <Alice@test.com>"), []byte("From: mallory@test.com"), 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = SmimeVerify(spoofed, roots); err == nil || !strings.Contains(err.Error(), "does not include the sender address 'mallory@test.com'") {
+			t.Errorf("SmimeVerify() = %v, want sender mismatch error", err)
+		}
+	})
+
+	t.Run("untrusted root", func(t *testing.T) {
+		if _, _, err := SmimeVerify(parsed, readCertPool(otherCert)); err == nil {
+			t.Error("SmimeVerify() = nil, want error for an untrusted root")
+		}
+	})
+
+	t.Run("certificate without email protection", func(t *testing.T) {
+		a := &Acert{
+			Hosts:           []string{"alice@test.com"},
+			RootCertificate: *parsePemCertificate(rootCert),
+			RootPrivateKey:  parsePemPrivateKey(rootKey),
+			Options:         AcertOptions{Days: 30, Algorithm: "ecdsa-p256", ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}},
+		}
+		leaf, err := x509.ParseCertificate(a.BuildCertificate(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := SmimeSign(m, leaf, nil, a.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseSmimeMessage(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = SmimeVerify(parsed, roots); err == nil {
+			t.Error("SmimeVerify() = nil, want error for a certificate without email protection")
+		}
+	})
+}
+
+func TestSmimeEncryptDecrypt(t *testing.T) {
+	dir := t.TempDir()
+	_, rootCert, rootKey := testAuthority(t, dir, "smime-root")
+	bob, bobKey := testSmimeCertificate(t, rootCert, rootKey, "bob@test.com", "rsa")
+	carol, carolKey := testSmimeCertificate(t, rootCert, rootKey, "carol@test.com", "rsa")
+	dave, daveKey := testSmimeCertificate(t, rootCert, rootKey, "dave@test.com", "rsa")
+	ecdsa, _ := testSmimeCertificate(t, rootCert, rootKey, "erin@test.com", "ecdsa-p256")
+
+	m := testSmimeMessage(t, "alice@test.com")
+	encrypted, err := SmimeEncrypt(m, []*x509.Certificate{bob, carol})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseSmimeMessage(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(parsed.Entity, []byte("The release is ready.")) {
+		t.Fatal("encrypted message contains the plain text content")
+	}
+
+	// Content is encrypted using AES-256-CBC
+	_, body := splitEntity(parsed.Entity)
+	envelope, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(body), nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aes256CBC, _ := asn1.Marshal(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42})
+	if !bytes.Contains(envelope, aes256CBC) {
+		t.Error("encrypted message does not use AES-256-CBC")
+	}
+
+	// Each recipient decrypts the original message
+	for _, recipient := range []struct {
+		cert *x509.Certificate
+		key  crypto.PrivateKey
+	}{{bob, bobKey}, {carol, carolKey}} {
+		decrypted, err := SmimeDecrypt(parsed, recipient.cert, recipient.key)
+		if err != nil {
+			t.Fatalf("%s: %v", recipient.cert.EmailAddresses[0], err)
+		}
+		if want := m.build(splitEntity(m.Entity)); !bytes.Equal(decrypted, want) {
+			t.Errorf("%s: decrypted = %q, want %q", recipient.cert.EmailAddresses[0], decrypted, want)
+		}
+	}
+
+	if _, err = SmimeDecrypt(parsed, dave, daveKey); err == nil {
+		t.Error("SmimeDecrypt() = nil, want error for a certificate that is not a recipient")
+	}
+	if _, err = SmimeDecrypt(m, bob, bobKey); err == nil || !strings.Contains(err.Error(), "not an S/MIME encrypted message") {
+		t.Errorf("SmimeDecrypt() = %v, want error for a message that is not encrypted", err)
+	}
+	if _, err = SmimeEncrypt(m, []*x509.Certificate{bob, ecdsa}); err == nil || !strings.Contains(err.Error(), "does not have an RSA key") {
+		t.Errorf("SmimeEncrypt() = %v, want error for a recipient without an RSA key", err)
+	}
+}
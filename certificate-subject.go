@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// SubjectAttribute describes a distinguished name attribute type
+type SubjectAttribute struct {
+	Type asn1.ObjectIdentifier
+
+	// ASN.1 string type used to encode values (utf8, printable or ia5)
+	Encoding string
+}
+
+// subjectAttributes maps lower case attribute names to attribute types.
+// Names follow RFC 4514 and common OpenSSL short names.
+// https://datatracker.ietf.org/doc/html/rfc4519#section-2
+var subjectAttributes = map[string]SubjectAttribute{
+	"c":                      {asn1.ObjectIdentifier{2, 5, 4, 6}, "printable"},
+	"countryname":            {asn1.ObjectIdentifier{2, 5, 4, 6}, "printable"},
+	"st":                     {asn1.ObjectIdentifier{2, 5, 4, 8}, "utf8"},
+	"s":                      {asn1.ObjectIdentifier{2, 5, 4, 8}, "utf8"},
+	"stateorprovincename":    {asn1.ObjectIdentifier{2, 5, 4, 8}, "utf8"},
+	"l":                      {asn1.ObjectIdentifier{2, 5, 4, 7}, "utf8"},
+	"localityname":           {asn1.ObjectIdentifier{2, 5, 4, 7}, "utf8"},
+	"street":                 {asn1.ObjectIdentifier{2, 5, 4, 9}, "utf8"},
+	"streetaddress":          {asn1.ObjectIdentifier{2, 5, 4, 9}, "utf8"},
+	"postalcode":             {asn1.ObjectIdentifier{2, 5, 4, 17}, "utf8"},
+	"o":                      {asn1.ObjectIdentifier{2, 5, 4, 10}, "utf8"},
+	"organizationname":       {asn1.ObjectIdentifier{2, 5, 4, 10}, "utf8"},
+	"ou":                     {asn1.ObjectIdentifier{2, 5, 4, 11}, "utf8"},
+	"organizationalunitname": {asn1.ObjectIdentifier{2, 5, 4, 11}, "utf8"},
+	"cn":                     {asn1.ObjectIdentifier{2, 5, 4, 3}, "utf8"},
+	"commonname":             {asn1.ObjectIdentifier{2, 5, 4, 3}, "utf8"},
+	"serialnumber":           {asn1.ObjectIdentifier{2, 5, 4, 5}, "printable"},
+	"title":                  {asn1.ObjectIdentifier{2, 5, 4, 12}, "utf8"},
+	"sn":                     {asn1.ObjectIdentifier{2, 5, 4, 4}, "utf8"},
+	"surname":                {asn1.ObjectIdentifier{2, 5, 4, 4}, "utf8"},
+	"gn":                     {asn1.ObjectIdentifier{2, 5, 4, 42}, "utf8"},
+	"givenname":              {asn1.ObjectIdentifier{2, 5, 4, 42}, "utf8"},
+	"initials":               {asn1.ObjectIdentifier{2, 5, 4, 43}, "utf8"},
+	"pseudonym":              {asn1.ObjectIdentifier{2, 5, 4, 65}, "utf8"},
+	"dnqualifier":            {asn1.ObjectIdentifier{2, 5, 4, 46}, "printable"},
+	"organizationidentifier": {asn1.ObjectIdentifier{2, 5, 4, 97}, "utf8"},
+	"dc":                     {asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}, "ia5"},
+	"domaincomponent":        {asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}, "ia5"},
+	"uid":                    {asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}, "utf8"},
+	"userid":                 {asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}, "utf8"},
+	"e":                      {asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}, "ia5"},
+	"email":                  {asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}, "ia5"},
+	"emailaddress":           {asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}, "ia5"},
+}
+
+// subjectAttributeType returns the attribute type of a distinguished name attribute.
+// Names are looked up in the attribute table, or are dotted object identifiers encoded as UTF8String.
+func subjectAttributeType(name string) (SubjectAttribute, error) {
+	attribute, ok := subjectAttributes[strings.ToLower(strings.TrimSpace(name))]
+	if ok {
+		return attribute, nil
+	}
+
+	oid, err := parseObjectIdentifier(name)
+	if err != nil && strings.Contains(name, ".") {
+		return attribute, err
+	} else if err != nil {
+		return attribute, fmt.Errorf("unknown subject attribute '%s'", name)
+	}
+	return SubjectAttribute{oid, "utf8"}, nil
+}
+
+// subjectAttribute encodes a distinguished name attribute string value
+func subjectAttribute(name string, value string) (pkix.AttributeTypeAndValue, error) {
+	attribute, err := subjectAttributeType(name)
+	if err != nil {
+		return pkix.AttributeTypeAndValue{}, err
+	}
+
+	der, err := asn1.MarshalWithParams(value, attribute.Encoding)
+	if err != nil {
+		return pkix.AttributeTypeAndValue{}, fmt.Errorf("invalid value for subject attribute '%s': %v", name, err)
+	}
+
+	return pkix.AttributeTypeAndValue{Type: attribute.Type, Value: asn1.RawValue{FullBytes: der}}, nil
+}
+
+// subjectAttributeDer sets a distinguished name attribute to a hex DER-encoded value (RFC 4514)
+func subjectAttributeDer(name string, value string) (pkix.AttributeTypeAndValue, error) {
+	attribute, err := subjectAttributeType(name)
+	if err != nil {
+		return pkix.AttributeTypeAndValue{}, err
+	}
+
+	der, err := hex.DecodeString(value)
+	if err == nil {
+		err = requireSingleDerValue(der)
+	}
+	if err != nil {
+		return pkix.AttributeTypeAndValue{}, fmt.Errorf("invalid value for subject attribute '%s': %v", name, err)
+	}
+
+	return pkix.AttributeTypeAndValue{Type: attribute.Type, Value: asn1.RawValue{FullBytes: der}}, nil
+}
+
+// splitEscaped splits a value by a separator that is not escaped with a backslash.
+// Separators within double quotes are also kept when quotes are enabled.
+func splitEscaped(value string, separator byte, quotes bool) []string {
+	var (
+		parts   []string
+		start   int
+		escaped bool
+		quoted  bool
+	)
+
+	for i := 0; i < len(value); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case value[i] == '\\':
+			escaped = true
+		case quotes && value[i] == '"':
+			quoted = !quoted
+		case value[i] == separator && !quoted:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, value[start:])
+}
+
+// unescapeValue removes backslash escapes, including RFC 4514 hex pairs (eg, \2C)
+func unescapeValue(value string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+2 < len(value) {
+			if decoded, err := hex.DecodeString(value[i+1 : i+3]); err == nil {
+				b.Write(decoded)
+				i += 2
+				continue
+			}
+		}
+		i++
+		b.WriteByte(value[i])
+	}
+
+	return b.String()
+}
+
+// attributeValue parses a distinguished name attribute value, reporting whether it is hex DER-encoded.
+// Values starting with an unescaped '#' are hex DER-encoded (RFC 4514), and
+// quoted values (eg, CN="Acme, Inc.") are accepted when quotes are enabled, as in RFC 2253.
+func attributeValue(value string, quotes bool) (string, bool, error) {
+	value = strings.TrimSpace(value)
+
+	switch {
+	case strings.HasPrefix(value, "#"):
+		return value[1:], true, nil
+	case quotes && strings.HasPrefix(value, `"`):
+		inner := strings.TrimSuffix(value[1:], `"`)
+		escapes := len(inner) - len(strings.TrimRight(inner, `\`))
+		if len(inner) == len(value)-1 || escapes%2 == 1 {
+			return "", false, fmt.Errorf("unterminated quoted value %s", value)
+		}
+		return unescapeValue(inner), false, nil
+	}
+
+	return unescapeValue(value), false, nil
+}
+
+// ParseDistinguishedName parses a distinguished name into an ordered RDN sequence.
+// Names in the OpenSSL format (eg, /C=US/O=Acme/OU=Eng/CN=svc) are listed from the first RDN,
+// while RFC 4514 strings (eg, CN=svc,OU=Eng,O=Acme,C=US) are listed from the last RDN.
+// Multi-valued RDNs join attributes with '+'.
+// Values in RFC 4514 strings may also be quoted to include separators.
+func ParseDistinguishedName(value string) (pkix.RDNSequence, error) {
+	value = strings.TrimSpace(value)
+	rfc4514 := !strings.HasPrefix(value, "/")
+
+	var rdns []string
+	if !rfc4514 {
+		rdns = splitEscaped(value[1:], '/', false)
+	} else {
+		rdns = splitEscaped(value, ',', true)
+		for i, j := 0, len(rdns)-1; i < j; i, j = i+1, j-1 {
+			rdns[i], rdns[j] = rdns[j], rdns[i]
+		}
+	}
+
+	var sequence pkix.RDNSequence
+	for _, rdn := range rdns {
+		if strings.TrimSpace(rdn) == "" {
+			continue
+		}
+
+		var set pkix.RelativeDistinguishedNameSET
+		for _, pair := range splitEscaped(rdn, '+', rfc4514) {
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid subject attribute '%s', expected TYPE=VALUE", strings.TrimSpace(pair))
+			}
+
+			text, encoded, err := attributeValue(parts[1], rfc4514)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for subject attribute '%s': %v", strings.TrimSpace(parts[0]), err)
+			}
+
+			var attribute pkix.AttributeTypeAndValue
+			if encoded {
+				attribute, err = subjectAttributeDer(parts[0], text)
+			} else {
+				attribute, err = subjectAttribute(parts[0], text)
+			}
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, attribute)
+		}
+		sequence = append(sequence, set)
+	}
+
+	if len(sequence) == 0 {
+		return nil, fmt.Errorf("empty distinguished name")
+	}
+	return sequence, nil
+}
+
+// SetSubjectSequence sets the subject from an RDN sequence.
+// The DER-encoded subject is used when building certificates and requests
+// to preserve the order of attributes, which x509 templates would normalize.
+func (a *Acert) SetSubjectSequence(sequence pkix.RDNSequence) {
+	raw, err := asn1.Marshal(sequence)
+	if err != nil {
+		panic(err)
+	}
+
+	var decoded pkix.RDNSequence
+	if _, err = asn1.Unmarshal(raw, &decoded); err != nil {
+		panic(err)
+	}
+
+	a.RawSubject = raw
+	a.Subject = pkix.Name{}
+	a.Subject.FillFromRDNSequence(&decoded)
+}
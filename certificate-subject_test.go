@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRDNs decodes a DER-encoded subject into its RDNs, listing attributes as OID=VALUE
+func testRDNs(t *testing.T, raw []byte) [][]string {
+	t.Helper()
+
+	var sequence pkix.RDNSequence
+	if rest, err := asn1.Unmarshal(raw, &sequence); err != nil || len(rest) != 0 {
+		t.Fatalf("could not decode subject: %v", err)
+	}
+
+	var rdns [][]string
+	for _, rdn := range sequence {
+		var attributes []string
+		for _, attribute := range rdn {
+			attributes = append(attributes, fmt.Sprintf("%s=%v", attribute.Type, attribute.Value))
+		}
+		rdns = append(rdns, attributes)
+	}
+	return rdns
+}
+
+func TestParseDistinguishedName(t *testing.T) {
+	ordered := [][]string{{"2.5.4.6=US"}, {"2.5.4.10=Acme"}, {"2.5.4.11=Eng"}, {"2.5.4.11=Platform"}, {"2.5.4.3=svc"}}
+
+	tests := []struct {
+		name  string
+		value string
+		want  [][]string
+		err   string
+	}{
+		{"openssl format", "/C=US/O=Acme/OU=Eng/OU=Platform/CN=svc", ordered, ""},
+		{"rfc 4514 strings are reversed", "CN=svc,OU=Platform,OU=Eng,O=Acme,C=US", ordered, ""},
+		{"long names and spaces", " commonName = svc , organizationalUnitName=Platform,OU=Eng,organizationName=Acme, countryName=US ", ordered, ""},
+		{"order differs from x509 templates", "/CN=svc/O=Acme/C=US", [][]string{{"2.5.4.3=svc"}, {"2.5.4.10=Acme"}, {"2.5.4.6=US"}}, ""},
+		{"empty rdns are skipped", "/C=US/O=Acme//OU=Eng/OU=Platform/CN=svc/", ordered, ""},
+
+		{"multi-valued rdn", "CN=svc+UID=42,O=Acme", [][]string{{"2.5.4.10=Acme"}, {"2.5.4.3=svc", "0.9.2342.19200300.100.1.1=42"}}, ""},
+		{"openssl multi-valued rdn", "/O=Acme/CN=svc+UID=42", [][]string{{"2.5.4.10=Acme"}, {"2.5.4.3=svc", "0.9.2342.19200300.100.1.1=42"}}, ""},
+
+		{"escaped separators", `CN=Acme\, Inc.,O=R\+D\=1`, [][]string{{"2.5.4.10=R+D=1"}, {"2.5.4.3=Acme, Inc."}}, ""},
+		{"escaped hex pairs", `CN=caf\C3\A9\2C bar`, [][]string{{"2.5.4.3=café, bar"}}, ""},
+		{"openssl escaped separators", `/O=a\/b/CN=c\+d`, [][]string{{"2.5.4.10=a/b"}, {"2.5.4.3=c+d"}}, ""},
+
+		{"quoted values", `CN="Acme, Inc.",O="R+D"`, [][]string{{"2.5.4.10=R+D"}, {"2.5.4.3=Acme, Inc."}}, ""},
+		{"quoted escapes", `CN="say \"hi\", \\o/"`, [][]string{{`2.5.4.3=say "hi", \o/`}}, ""},
+		{"openssl quotes are literal", `/CN=6" screen`, [][]string{{`2.5.4.3=6" screen`}}, ""},
+		{"unterminated quote", `CN="Acme, Inc.`, nil, "unterminated quoted value"},
+		{"escaped closing quote", `CN="Acme\"`, nil, "unterminated quoted value"},
+
+		{"hex value", "CN=#0c03616263", [][]string{{"2.5.4.3=abc"}}, ""},
+		{"openssl hex value", "/CN=#0c03616263", [][]string{{"2.5.4.3=abc"}}, ""},
+		{"escaped hash", `CN=\#abc`, [][]string{{"2.5.4.3=#abc"}}, ""},
+		{"quoted hash", `CN="#abc"`, [][]string{{"2.5.4.3=#abc"}}, ""},
+		{"invalid hex value", "CN=#zz", nil, "invalid value for subject attribute 'CN'"},
+		{"trailing hex data", "CN=#0c0161ff", nil, "invalid value for subject attribute 'CN'"},
+
+		{"dotted object identifiers", "1.3.6.1.4.1.99999.1=custom,2.5.4.3=svc", [][]string{{"2.5.4.3=svc"}, {"1.3.6.1.4.1.99999.1=custom"}}, ""},
+		{"invalid object identifier", "1.40=x", nil, "object identifier"},
+		{"unknown attribute", "XX=1", nil, "unknown subject attribute 'XX'"},
+		{"missing value", "CN=svc,O", nil, "expected TYPE=VALUE"},
+		{"unprintable country", "C=ü", nil, "invalid value for subject attribute 'C'"},
+		{"empty", " ", nil, "empty distinguished name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sequence, err := ParseDistinguishedName(tt.value)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParseDistinguishedName() = %v, want error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var a Acert
+			a.SetSubjectSequence(sequence)
+			if got := testRDNs(t, a.RawSubject); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("subject = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubjectAttributeEncoding(t *testing.T) {
+	sequence, err := ParseDistinguishedName("/C=US/DC=test/CN=svc/1.3.6.1.4.1.99999.1=custom/serialNumber=42")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Country names and serial numbers are PrintableString, domain components IA5String
+	want := []int{asn1.TagPrintableString, asn1.TagIA5String, asn1.TagUTF8String, asn1.TagUTF8String, asn1.TagPrintableString}
+	for i, rdn := range sequence {
+		raw, ok := rdn[0].Value.(asn1.RawValue)
+		if !ok || len(raw.FullBytes) == 0 || int(raw.FullBytes[0]) != want[i] {
+			t.Errorf("%s encoding = %v, want tag %d", rdn[0].Type, rdn[0].Value, want[i])
+		}
+	}
+}
+
+func TestUnescapeValue(t *testing.T) {
+	tests := map[string]string{
+		`plain`:        "plain",
+		`a\,b`:         "a,b",
+		`\2C`:          ",",
+		`\2c\2B`:       ",+",
+		`\\`:           `\`,
+		`caf\C3\A9`:    "café",
+		`\"quoted\"`:   `"quoted"`,
+		`\zz`:          "zz",
+		`\4`:           "4",
+		`trailing\`:    `trailing\`,
+		`\#not-hex`:    "#not-hex",
+		`space\ after`: "space after",
+	}
+
+	for value, want := range tests {
+		if got := unescapeValue(value); got != want {
+			t.Errorf("unescapeValue(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestSetSubjectSequence(t *testing.T) {
+	sequence, err := ParseDistinguishedName("CN=svc+UID=42,OU=Platform,OU=Eng,O=Acme,C=US")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := Acert{Hosts: []string{"svc.test"}, Options: AcertOptions{Days: 1, Algorithm: "ecdsa-p256"}}
+	a.SetSubjectSequence(sequence)
+
+	// Subject fields are filled for naming files and messages
+	if a.Subject.CommonName != "svc" || !reflect.DeepEqual(a.Subject.OrganizationalUnit, []string{"Eng", "Platform"}) || !reflect.DeepEqual(a.Subject.Country, []string{"US"}) {
+		t.Errorf("subject = %+v, want fields from the sequence", a.Subject)
+	}
+
+	// Certificates and requests use the DER-encoded subject as is
+	cert, err := x509.ParseCertificate(a.BuildCertificate(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cert.RawSubject, a.RawSubject) {
+		t.Errorf("certificate subject = %v, want %v", testRDNs(t, cert.RawSubject), testRDNs(t, a.RawSubject))
+	}
+
+	b := Acert{Options: AcertOptions{Algorithm: "ecdsa-p256"}}
+	b.SetSubjectSequence(sequence)
+	request, err := x509.ParseCertificateRequest(b.BuildCertificateRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(request.RawSubject, a.RawSubject) {
+		t.Errorf("request subject = %v, want %v", testRDNs(t, request.RawSubject), testRDNs(t, a.RawSubject))
+	}
+}
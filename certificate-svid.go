@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lstellway/go/command"
+)
+
+// SVIDs are short-lived by default, as workloads are expected to rotate them
+const defaultSvidValidity = "1h"
+
+// ParseSpiffeID parses and validates a SPIFFE ID (eg, spiffe://example.org/ns/prod/web)
+// https://github.com/spiffe/spiffe/blob/main/standards/SPIFFE-ID.md
+func ParseSpiffeID(value string) (*url.URL, error) {
+	id, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SPIFFE ID '%s': %v", value, err)
+	}
+
+	switch {
+	case id.Scheme != "spiffe":
+		return nil, fmt.Errorf("invalid SPIFFE ID '%s': the scheme must be 'spiffe'", value)
+	case id.User != nil, id.Port() != "", id.RawQuery != "", id.Fragment != "":
+		return nil, fmt.Errorf("invalid SPIFFE ID '%s': user info, ports, queries and fragments are not allowed", value)
+	}
+
+	if err = validateTrustDomain(id.Host); err != nil {
+		return nil, fmt.Errorf("invalid SPIFFE ID '%s': %v", value, err)
+	}
+
+	if id.Path != "" {
+		for _, segment := range strings.Split(id.Path, "/")[1:] {
+			if segment == "" || segment == "." || segment == ".." || strings.Trim(segment, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.-_") != "" {
+				return nil, fmt.Errorf("invalid SPIFFE ID '%s': invalid path segment '%s'", value, segment)
+			}
+		}
+	}
+
+	return id, nil
+}
+
+// validateTrustDomain checks that a trust domain name only contains
+// lower case letters, numbers, dots, dashes and underscores
+func validateTrustDomain(trustDomain string) error {
+	if trustDomain == "" {
+		return fmt.Errorf("a trust domain is required")
+	}
+	if strings.Trim(trustDomain, "abcdefghijklmnopqrstuvwxyz0123456789.-_") != "" {
+		return fmt.Errorf("invalid trust domain '%s'", trustDomain)
+	}
+	return nil
+}
+
+// certificateTrustDomain returns the trust domain of a SPIFFE certificate
+func certificateTrustDomain(cert *x509.Certificate) string {
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			return uri.Host
+		}
+	}
+	return ""
+}
+
+// svidFileName builds a file name from a SPIFFE ID (eg, example.org_ns_prod_web)
+func svidFileName(id *url.URL) string {
+	return strings.ReplaceAll(strings.TrimSuffix(id.Host+id.Path, "/"), "/", "_")
+}
+
+// NewSvidAuthority configures a trust domain authority.
+// Name constraints limit the authority to issuing identities in the trust domain.
+func NewSvidAuthority(trustDomain string) (*Acert, error) {
+	if err := validateTrustDomain(trustDomain); err != nil {
+		return nil, err
+	}
+
+	profile, err := lookupProfile("spiffe-ca")
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Acert{
+		Hosts:   []string{"uri:spiffe://" + trustDomain},
+		Subject: SubjectFields{CommonName: trustDomain}.Name(),
+	}
+	a.Options.PermittedURIDomains = []string{trustDomain}
+	profile.Apply(&a.Options)
+	return a, nil
+}
+
+// NewSvid configures an X.509-SVID with the SPIFFE ID as its only subject alternative name
+// https://github.com/spiffe/spiffe/blob/main/standards/X509-SVID.md
+func NewSvid(id *url.URL, parent *x509.Certificate) (*Acert, error) {
+	if id.Path == "" {
+		return nil, fmt.Errorf("the SPIFFE ID of a workload must have a path")
+	}
+	if !parent.IsCA {
+		return nil, fmt.Errorf("the parent certificate '%s' is not an authority", parent.Subject)
+	}
+	if trustDomain := certificateTrustDomain(parent); trustDomain != "" && trustDomain != id.Host {
+		return nil, fmt.Errorf("the SPIFFE ID trust domain '%s' does not match the authority trust domain '%s'", id.Host, trustDomain)
+	}
+
+	profile, err := lookupProfile("svid")
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Acert{
+		Hosts:           []string{"uri:" + id.String()},
+		RootCertificate: *parent,
+	}
+	profile.Apply(&a.Options)
+	return a, nil
+}
+
+// SpiffeBundle is a SPIFFE trust bundle in JSON Web Key Set form
+// https://github.com/spiffe/spiffe/blob/main/standards/SPIFFE_Trust_Domain_and_Bundle.md#4-spiffe-bundle-format
+type SpiffeBundle struct {
+	Keys        []JSONWebKey `json:"keys"`
+	Sequence    int64        `json:"spiffe_sequence,omitempty"`
+	RefreshHint int64        `json:"spiffe_refresh_hint,omitempty"`
+}
+
+// JSONWebKey is a public key of an authority in a trust bundle
+type JSONWebKey struct {
+	Use string   `json:"use"`
+	Kty string   `json:"kty"`
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	X5c []string `json:"x5c"`
+}
+
+// NewSpiffeBundle builds a trust bundle for X.509-SVID authorities
+func NewSpiffeBundle(authorities []*x509.Certificate, refreshHint time.Duration) (SpiffeBundle, error) {
+	bundle := SpiffeBundle{
+		Sequence:    time.Now().Unix(),
+		RefreshHint: int64(refreshHint.Seconds()),
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	for _, cert := range authorities {
+		key := JSONWebKey{Use: "x509-svid", X5c: []string{base64.StdEncoding.EncodeToString(cert.Raw)}}
+
+		switch pub := cert.PublicKey.(type) {
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			key.Kty = "EC"
+			key.Crv = pub.Curve.Params().Name
+			key.X = encode(pub.X.FillBytes(make([]byte, size)))
+			key.Y = encode(pub.Y.FillBytes(make([]byte, size)))
+		case *rsa.PublicKey:
+			key.Kty = "RSA"
+			key.N = encode(pub.N.Bytes())
+			key.E = encode(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			key.Kty = "OKP"
+			key.Crv = "Ed25519"
+			key.X = encode(pub)
+		default:
+			return bundle, fmt.Errorf("unsupported public key type %T of '%s'", pub, cert.Subject)
+		}
+
+		bundle.Keys = append(bundle.Keys, key)
+	}
+
+	return bundle, nil
+}
+
+// svidCommand defines the CLI commands to issue SPIFFE X.509-SVIDs
+func svidCommand(flags ...string) {
+	// Initialize command
+	cmd, args = newCommand(commandName("svid"), "Issue SPIFFE X.509-SVIDs and trust bundles", func(h *command.Command) {
+		h.AddSubcommand("help", "Display this help screen")
+		h.AddSubcommand("ca", "Create a trust domain authority")
+		h.AddSubcommand("issue", "Issue an X.509-SVID for a SPIFFE ID")
+		h.AddSubcommand("bundle", "Export a trust bundle in JWKS form")
+	}, flags...)
+
+	switch getArgument(true) {
+	case "ca":
+		svidAuthority(args...)
+	case "issue":
+		svidIssue(args...)
+	case "bundle":
+		svidBundle(args...)
+	default:
+		cmd.Usage()
+	}
+}
+
+// svidAuthority creates a trust domain authority
+func svidAuthority(flags ...string) {
+	var trustDomain string
+
+	// Initialize command
+	cmd, args = newCommand(commandName("svid ca"), "Create a trust domain authority", func(h *command.Command) {
+		h.AddSection("General Options", func(s *command.CommandSection) {
+			generalFlags(s)
+		})
+		h.AddSection("Private Key Options", func(s *command.CommandSection) {
+			certificateKeyFlags(s)
+		})
+		h.AddSection("Certificate Options", func(s *command.CommandSection) {
+			s.StringVar(&trustDomain, "trustDomain", "", "SPIFFE trust domain (eg, example.org)")
+			s.IntVar(&days, "days", 365, "Number of days the authority should be valid for")
+			certificateValidityFlags(s)
+			s.StringVar(&parent, "parent", "", "Path to PEM-encoded upstream authority certificate (self-signed when not set)")
+			s.StringVar(&key, "key", "", "Path to PEM-encoded private key of the upstream authority")
+			s.IntVar(&pathLenConstraint, "pathLength", 0, "Maximum number of intermediate authorities that may follow this authority")
+		})
+
+		h.AddExample("Create a trust domain authority", "-trustDomain example.org -ecdsa")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	switch getArgument(true) {
+	case "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&outputDirectory, "output")
+
+		a, err := NewSvidAuthority(trustDomain)
+		exitOnError(err, "Invalid value for 'trustDomain' argument:", err)
+
+		if parent != "" || key != "" {
+			requireFileValue(&parent, "parent")
+			requireFileValue(&key, "key")
+			warnInsecureKeyPermissions(key)
+			a.RootCertificate = *parsePemCertificate(parent)
+			a.RootPrivateKey = parsePemPrivateKey(key)
+			requireMatchingKey(&a.RootCertificate, a.RootPrivateKey, key, parent)
+		}
+
+		svidValidity(a)
+		a.Options.PathLenConstraint = pathLenConstraint
+		bytes := a.BuildCertificate(true)
+		saveCertificatePem(trustDomain+".ca", bytes, false)
+		savePrivateKeyPem(trustDomain+".ca", a.PrivateKey)
+	}
+}
+
+// svidIssue issues an X.509-SVID
+func svidIssue(flags ...string) {
+	var name string
+
+	// Initialize command
+	cmd, args = newCommand(commandName("svid issue"), "Issue an X.509-SVID for a SPIFFE ID", func(h *command.Command) {
+		h.AddSection("General Options", func(s *command.CommandSection) {
+			generalFlags(s)
+			s.StringVar(&name, "name", "", "Base name of the saved files (defaults to the SPIFFE ID, eg, example.org_ns_prod_web)")
+		})
+		h.AddSection("Private Key Options", func(s *command.CommandSection) {
+			certificateKeyFlags(s)
+		})
+		h.AddSection("Certificate Options", func(s *command.CommandSection) {
+			certificateValidityFlags(s)
+			s.StringVar(&parent, "parent", "", "Path to PEM-encoded trust domain authority certificate")
+			s.StringVar(&key, "key", "", "Path to PEM-encoded private key of the trust domain authority")
+		})
+
+		h.AddArgument("SPIFFE_ID")
+
+		h.AddExample("Issue an SVID valid for 1 hour", "-parent example.org.ca.cert.pem -key example.org.ca.key.pem spiffe://example.org/ns/prod/web")
+		h.AddExample("Issue an SVID valid for 15 minutes", "-parent example.org.ca.cert.pem -key example.org.ca.key.pem -validity 15m spiffe://example.org/ns/prod/web")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	arg := getArgument(true)
+
+	switch arg {
+	case "", "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&outputDirectory, "output")
+		requireFileValue(&parent, "parent")
+		requireFileValue(&key, "key")
+		warnInsecureKeyPermissions(key)
+
+		id, err := ParseSpiffeID(arg)
+		exitOnError(err, "Could not issue SVID:", err)
+
+		a, err := NewSvid(id, parsePemCertificate(parent))
+		exitOnError(err, "Could not issue SVID:", err)
+		a.RootPrivateKey = parsePemPrivateKey(key)
+		requireMatchingKey(&a.RootCertificate, a.RootPrivateKey, key, parent)
+
+		if validity == "" && notAfter == "" {
+			validity = defaultSvidValidity
+		}
+		svidValidity(a)
+
+		name = defaultValue(name, svidFileName(id))
+		bytes := a.BuildCertificate(false)
+		saveCertificatePem(name, bytes, false)
+		savePrivateKeyPem(name, a.PrivateKey)
+	}
+}
+
+// svidValidity applies the key and validity options to an SVID or authority
+func svidValidity(a *Acert) {
+	a.Options.Algorithm = keyAlgorithm()
+	a.Options.Bits = bits
+	a.Options.Days = days
+	a.Options.NotBefore = parseTimeValue(notBefore, "notBefore")
+	a.Options.NotAfter = parseTimeValue(notAfter, "notAfter")
+	a.Options.Validity = parseValidityValue(validity, "validity")
+	a.Options.Backdate = parseDurationValue(backdate, "backdate")
+
+	err := a.CheckValidity()
+	exitOnError(err, "Invalid validity period:", err)
+}
+
+// svidBundle exports a trust bundle
+func svidBundle(flags ...string) {
+	var out, refreshHint string
+
+	// Initialize command
+	cmd, args = newCommand(commandName("svid bundle"), "Export a trust bundle in JWKS form", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&out, "out", "", "Path to save the bundle to (defaults to TRUST_DOMAIN.bundle.json)")
+			s.StringVar(&refreshHint, "refreshHint", "5m", "Duration after which consumers should check for an updated bundle")
+		})
+
+		h.AddArgument("AUTHORITY_FILE...")
+
+		h.AddExample("Export the trust bundle of a trust domain", "example.org.ca.cert.pem")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	switch getArgument(false) {
+	case "", "help":
+		cmd.Usage()
+	default:
+		var authorities []*x509.Certificate
+		for _, file := range args {
+			requireFileValue(&file, "AUTHORITY_FILE")
+			certs, err := readPemCertificates(file)
+			exitOnError(err, "Could not read certificates:", file, err)
+			authorities = append(authorities, certs...)
+		}
+
+		trustDomain := certificateTrustDomain(authorities[0])
+		for _, cert := range authorities {
+			if !cert.IsCA {
+				exit(1, fmt.Sprintf("Certificate '%s' is not an authority", cert.Subject))
+			}
+			if td := certificateTrustDomain(cert); td != trustDomain {
+				exit(1, fmt.Sprintf("Certificate '%s' belongs to trust domain '%s', expected '%s'", cert.Subject, td, trustDomain))
+			}
+		}
+		if trustDomain == "" {
+			exit(1, "Authorities do not include a SPIFFE trust domain")
+		}
+
+		bundle, err := NewSpiffeBundle(authorities, parseDurationValue(refreshHint, "refreshHint"))
+		exitOnError(err, "Could not build trust bundle:", err)
+
+		data, err := json.MarshalIndent(bundle, "", "  ")
+		exitOnError(err, "Could not encode trust bundle:", err)
+		saveFile(defaultValue(out, trustDomain+".bundle.json"), append(data, '\n'), 0644, true)
+	}
+}
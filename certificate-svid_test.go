@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSvidAuthority creates a self-signed trust domain authority and saves its certificate to a directory
+func testSvidAuthority(t *testing.T, dir string, trustDomain string) (*Acert, *x509.Certificate, string) {
+	t.Helper()
+
+	a, err := NewSvidAuthority(trustDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Options.Algorithm = "ecdsa-p256"
+	a.Options.Days = 1
+	der := a.BuildCertificate(true)
+
+	file := filepath.Join(dir, trustDomain+".ca.cert.pem")
+	if err = os.WriteFile(file, pemEncode("CERTIFICATE", der), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, cert, file
+}
+
+func TestParseSpiffeID(t *testing.T) {
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{"spiffe://example.org", ""},
+		{"spiffe://example.org/ns/prod/web", ""},
+		{"spiffe://example.org/A_b-c.d", ""},
+		{"https://example.org/ns/prod", "the scheme must be 'spiffe'"},
+		{"spiffe:///ns/prod", "a trust domain is required"},
+		{"spiffe://Example.org/web", "invalid trust domain 'Example.org'"},
+		{"spiffe://exa mple.org/web", "invalid SPIFFE ID"},
+		{"spiffe://user@example.org/web", "user info, ports, queries and fragments are not allowed"},
+		{"spiffe://example.org:8443/web", "user info, ports, queries and fragments are not allowed"},
+		{"spiffe://example.org/web?x=1", "user info, ports, queries and fragments are not allowed"},
+		{"spiffe://example.org/web#x", "user info, ports, queries and fragments are not allowed"},
+		{"spiffe://example.org/", "invalid path segment ''"},
+		{"spiffe://example.org/ns//web", "invalid path segment ''"},
+		{"spiffe://example.org/ns/./web", "invalid path segment '.'"},
+		{"spiffe://example.org/ns/../web", "invalid path segment '..'"},
+		{"spiffe://example.org/ns/web%20app", "invalid path segment 'web app'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			id, err := ParseSpiffeID(tt.value)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("ParseSpiffeID() = %v, want nil", err)
+			case tt.err == "" && id.String() != tt.value:
+				t.Errorf("ParseSpiffeID() = %s, want %s", id, tt.value)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("ParseSpiffeID() = %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewSvid(t *testing.T) {
+	dir := t.TempDir()
+	_, authority, _ := testSvidAuthority(t, dir, "example.org")
+	_, plainCert, plainKey := testAuthority(t, dir, "plain-root")
+	leafCert, _ := testIssue(t, dir, plainCert, plainKey, "leaf.test")
+
+	tests := []struct {
+		name   string
+		id     string
+		parent *x509.Certificate
+		err    string
+	}{
+		{"workload", "spiffe://example.org/ns/prod/web", authority, ""},
+		{"authority without trust domain", "spiffe://other.org/web", testCertificate(t, plainCert), ""},
+		{"trust domain mismatch", "spiffe://other.org/web", authority, "does not match the authority trust domain 'example.org'"},
+		{"missing path", "spiffe://example.org", authority, "must have a path"},
+		{"parent is not an authority", "spiffe://example.org/web", testCertificate(t, leafCert), "is not an authority"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := ParseSpiffeID(tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = NewSvid(id, tt.parent)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("NewSvid() = %v, want nil", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("NewSvid() = %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSvidCertificate(t *testing.T) {
+	ca, authority, _ := testSvidAuthority(t, t.TempDir(), "example.org")
+
+	// Authorities are constrained to their trust domain
+	if !authority.IsCA || authority.KeyUsage != x509.KeyUsageCertSign|x509.KeyUsageCRLSign {
+		t.Errorf("authority = CA %v with key usage %v, want CA signing certificates and CRLs", authority.IsCA, authority.KeyUsage)
+	}
+	if len(authority.PermittedURIDomains) != 1 || authority.PermittedURIDomains[0] != "example.org" {
+		t.Errorf("permitted URI domains = %v, want [example.org]", authority.PermittedURIDomains)
+	}
+
+	id, err := ParseSpiffeID("spiffe://example.org/ns/prod/web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewSvid(id, authority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.RootPrivateKey = ca.PrivateKey
+	a.Options.Algorithm = "ecdsa-p256"
+	a.Options.Validity = time.Hour
+	svid, err := x509.ParseCertificate(a.BuildCertificate(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(svid.URIs) != 1 || svid.URIs[0].String() != id.String() {
+		t.Errorf("URIs = %v, want only %s", svid.URIs, id)
+	}
+	if len(svid.DNSNames) != 0 || len(svid.IPAddresses) != 0 || len(svid.EmailAddresses) != 0 {
+		t.Errorf("names = %v %v %v, want only the SPIFFE ID", svid.DNSNames, svid.IPAddresses, svid.EmailAddresses)
+	}
+	if svid.IsCA {
+		t.Error("SVID is an authority")
+	}
+	if svid.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Errorf("key usage = %v, want digital signature only", svid.KeyUsage)
+	}
+	if len(svid.RawSubject) != 2 || len(svid.Subject.Names) != 0 {
+		t.Errorf("subject = %q, want an empty subject", svid.Subject)
+	}
+	for _, extension := range svid.Extensions {
+		if extension.Id.Equal(oidExtensionSubjectAltName) && !extension.Critical {
+			t.Error("subject alternative name extension is not critical with an empty subject")
+		}
+	}
+	if lifetime := svid.NotAfter.Sub(time.Now()); lifetime > time.Hour {
+		t.Errorf("lifetime = %s, want at most 1h", lifetime)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(authority)
+	if _, err = svid.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("Verify() = %v, want nil", err)
+	}
+}
+
+func TestNewSpiffeBundle(t *testing.T) {
+	decode := func(t *testing.T, value string) []byte {
+		t.Helper()
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	t.Run("ecdsa coordinates are padded", func(t *testing.T) {
+		// Find a key with a coordinate shorter than the curve size
+		var key *ecdsa.PrivateKey
+		for i := 0; i < 100000 && (key == nil || (key.X.BitLen() > 248 && key.Y.BitLen() > 248)); i++ {
+			var err error
+			if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if key.X.BitLen() > 248 && key.Y.BitLen() > 248 {
+			t.Fatal("could not generate a key with a short coordinate")
+		}
+
+		bundle, err := NewSpiffeBundle([]*x509.Certificate{{PublicKey: &key.PublicKey, Raw: []byte("der")}}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwk := bundle.Keys[0]
+		x, y := decode(t, jwk.X), decode(t, jwk.Y)
+		if jwk.Kty != "EC" || jwk.Crv != "P-256" || len(x) != 32 || len(y) != 32 {
+			t.Fatalf("key = %s %s with %d and %d byte coordinates, want EC P-256 with 32 byte coordinates", jwk.Kty, jwk.Crv, len(x), len(y))
+		}
+		if new(big.Int).SetBytes(x).Cmp(key.X) != 0 || new(big.Int).SetBytes(y).Cmp(key.Y) != 0 {
+			t.Error("coordinates do not match the public key")
+		}
+		if jwk.Use != "x509-svid" || len(jwk.X5c) != 1 || jwk.X5c[0] != base64.StdEncoding.EncodeToString([]byte("der")) {
+			t.Errorf("key = use %s with x5c %v, want x509-svid with the certificate", jwk.Use, jwk.X5c)
+		}
+		if bundle.RefreshHint != 60 || bundle.Sequence <= 0 {
+			t.Errorf("bundle = refresh hint %d sequence %d, want 60 and a positive sequence", bundle.RefreshHint, bundle.Sequence)
+		}
+	})
+
+	t.Run("rsa", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle, err := NewSpiffeBundle([]*x509.Certificate{{PublicKey: &key.PublicKey}}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwk := bundle.Keys[0]
+		if jwk.Kty != "RSA" || jwk.E != "AQAB" || new(big.Int).SetBytes(decode(t, jwk.N)).Cmp(key.N) != 0 {
+			t.Errorf("key = %s with e %s, want RSA with the public modulus and e AQAB", jwk.Kty, jwk.E)
+		}
+	})
+
+	t.Run("ed25519", func(t *testing.T) {
+		public, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle, err := NewSpiffeBundle([]*x509.Certificate{{PublicKey: public}}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jwk := bundle.Keys[0]; jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || !bytes.Equal(decode(t, jwk.X), public) {
+			t.Errorf("key = %s %s, want OKP Ed25519 with the public key", jwk.Kty, jwk.Crv)
+		}
+	})
+
+	t.Run("unsupported key", func(t *testing.T) {
+		if _, err := NewSpiffeBundle([]*x509.Certificate{{PublicKey: "key"}}, 0); err == nil || !strings.Contains(err.Error(), "unsupported public key type") {
+			t.Errorf("NewSpiffeBundle() = %v, want unsupported key error", err)
+		}
+	})
+}
+
+func TestSvidBundle(t *testing.T) {
+	dir := t.TempDir()
+	_, authority, file := testSvidAuthority(t, dir, "example.org")
+	out := filepath.Join(dir, "bundle.json")
+
+	svidBundle("-out", out, "-refreshHint", "10m", file)
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// JWKS consumers read the keys with the standard member names
+	var bundle struct {
+		Keys []struct {
+			Use string   `json:"use"`
+			Kty string   `json:"kty"`
+			Crv string   `json:"crv"`
+			X   string   `json:"x"`
+			Y   string   `json:"y"`
+			X5c []string `json:"x5c"`
+		} `json:"keys"`
+		Sequence    int64 `json:"spiffe_sequence"`
+		RefreshHint int64 `json:"spiffe_refresh_hint"`
+	}
+	if err = json.Unmarshal(data, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Keys) != 1 || bundle.RefreshHint != 600 || bundle.Sequence <= 0 {
+		t.Fatalf("bundle = %s, want a single key with a refresh hint of 600", data)
+	}
+
+	jwk := bundle.Keys[0]
+	der, err := base64.StdEncoding.DecodeString(jwk.X5c[0])
+	if err != nil || !bytes.Equal(der, authority.Raw) {
+		t.Errorf("x5c = %v, want the authority certificate", err)
+	}
+
+	x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+	y, _ := base64.RawURLEncoding.DecodeString(jwk.Y)
+	public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if jwk.Use != "x509-svid" || jwk.Kty != "EC" || jwk.Crv != "P-256" || !public.Equal(authority.PublicKey) {
+		t.Errorf("key = %s %s %s, want the authority EC P-256 public key for x509-svid", jwk.Use, jwk.Kty, jwk.Crv)
+	}
+}
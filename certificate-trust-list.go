@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lstellway/go/command"
+)
+
+// linuxTrustBundles lists system certificate bundles generated from the trust anchors
+var linuxTrustBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// acertAnchorPattern matches anchor file names created by TrustLinux,
+// which appends a Unix timestamp and the store extension to the certificate file name
+// (eg, local-root.ca.cert.pem-1700000000.crt). Source file names can have any extension.
+var acertAnchorPattern = regexp.MustCompile(`^.+-\d{10,}\.(pem|crt)$`)
+
+// TrustedCertificate describes a certificate found in a trust store
+type TrustedCertificate struct {
+	Source      string    `json:"source"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"notAfter"`
+	Expired     bool      `json:"expired"`
+	Acert       bool      `json:"acert"`
+}
+
+// readTrustedCertificates parses every certificate in a file.
+// Blocks that cannot be parsed are skipped, so one bad entry does not hide the rest of a bundle.
+func readTrustedCertificates(file string) []TrustedCertificate {
+	var trusted []TrustedCertificate
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return trusted
+	}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+
+		trusted = append(trusted, TrustedCertificate{
+			Source:      file,
+			Subject:     c.Subject.String(),
+			Issuer:      c.Issuer.String(),
+			Fingerprint: certificateFingerprint(c, crypto.SHA256),
+			NotAfter:    c.NotAfter,
+			Expired:     now.After(c.NotAfter),
+		})
+	}
+
+	return trusted
+}
+
+// ListTrustedCertificates enumerates certificates in the Linux anchor directories and system bundles.
+// Anchors named by TrustLinux are reported as installed by acert,
+// as are bundle entries matching the fingerprint of an acert anchor.
+func ListTrustedCertificates() []TrustedCertificate {
+	var (
+		trusted      []TrustedCertificate
+		fingerprints = map[string]bool{}
+		bundles      = linuxTrustBundles
+	)
+
+	// Only the configured store is listed when using a custom store
+	if trustStore != "" {
+		bundles = nil
+	}
+
+	for _, store := range configuredLinuxTrustStores() {
+		entries, err := os.ReadDir(store.Directory)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+
+			isAcert := acertAnchorPattern.MatchString(entry.Name())
+			for _, t := range readTrustedCertificates(path.Join(store.Directory, entry.Name())) {
+				t.Acert = isAcert
+				if isAcert {
+					fingerprints[t.Fingerprint] = true
+				}
+				trusted = append(trusted, t)
+			}
+		}
+	}
+
+	for _, bundle := range bundles {
+		for _, t := range readTrustedCertificates(bundle) {
+			t.Acert = fingerprints[t.Fingerprint]
+			trusted = append(trusted, t)
+		}
+	}
+
+	return trusted
+}
+
+// listTrustedCertificates prints trusted certificates as a table or JSON
+func listTrustedCertificates(asJson bool, acertOnly bool) {
+	var trusted []TrustedCertificate
+	for _, t := range ListTrustedCertificates() {
+		if !acertOnly || t.Acert {
+			trusted = append(trusted, t)
+		}
+	}
+
+	sort.SliceStable(trusted, func(i, j int) bool {
+		return trusted[i].Acert && !trusted[j].Acert
+	})
+
+	if asJson {
+		if trusted == nil {
+			trusted = []TrustedCertificate{}
+		}
+		data, err := json.MarshalIndent(trusted, "", "  ")
+		exitOnError(err, err)
+		log(string(data))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACERT\tEXPIRES\tFINGERPRINT (SHA-256)\tSUBJECT\tSOURCE")
+	for _, t := range trusted {
+		installed := ""
+		if t.Acert {
+			installed = "yes"
+		}
+		expires := t.NotAfter.Format("2006-01-02")
+		if t.Expired {
+			expires += " (expired)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", installed, expires, t.Fingerprint, t.Subject, t.Source)
+	}
+	w.Flush()
+}
+
+// trustListCommand defines the CLI command to list trusted certificates.
+func trustListCommand(flags ...string) {
+	var asJson, acertOnly bool
+
+	// Initialize command
+	cmd, args = newCommand(commandName("trust list"), "List certificates in the system trust store", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.BoolVar(&asJson, "json", false, "Output certificates as JSON")
+			s.BoolVar(&acertOnly, "acert", false, "Only list certificates installed by acert")
+			s.StringVar(&trustStore, "store", "", "Directory of trust anchors to list instead of the host trust store")
+		})
+
+		h.AddExample("List certificates installed by acert", "-acert")
+		h.AddExample("List all trusted certificates as JSON", "-json")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	switch getArgument(true) {
+	case "help":
+		cmd.Usage()
+	default:
+		listTrustedCertificates(asJson, acertOnly)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcertAnchorPattern(t *testing.T) {
+	tests := map[string]bool{
+		"local-root.ca.cert.pem-1700000000.crt": true,
+		"local-root.ca.cert.pem-1700000000.pem": true,
+		"root.crt-1700000000.crt":               true,
+		"root.ca-1700000000.crt":                true,
+		"ca.der-1700000000.pem":                 true,
+		"root-1700000000.crt":                   true,
+		"foo-2019.crt":                          false,
+		"DigiCert_Global_Root_G2-2013.pem":      false,
+		"local-root.ca.cert.pem":                false,
+		"local-root.ca.cert.pem-1700000000.txt": false,
+		"root.ca-170000000.crt":                 false,
+		"-1700000000.crt":                       false,
+	}
+
+	for name, want := range tests {
+		if got := acertAnchorPattern.MatchString(name); got != want {
+			t.Errorf("acertAnchorPattern.MatchString(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestReadTrustedCertificates(t *testing.T) {
+	dir := t.TempDir()
+	_, first, _ := testAuthority(t, dir, "first-root")
+	_, second, _ := testAuthority(t, dir, "second-root")
+
+	// A block that cannot be parsed does not hide the other certificates
+	bundle := filepath.Join(dir, "bundle.pem")
+	data := append(readFile(first), pemEncode("CERTIFICATE", []byte("invalid"))...)
+	data = append(data, readFile(second)...)
+	if err := os.WriteFile(bundle, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	trusted := readTrustedCertificates(bundle)
+	if len(trusted) != 2 || trusted[0].Subject != "CN=first-root" || trusted[1].Subject != "CN=second-root" {
+		t.Fatalf("readTrustedCertificates() = %+v, want first-root and second-root", trusted)
+	}
+}
+
+func TestListTrustedCertificates(t *testing.T) {
+	dir := t.TempDir()
+	_, cert, _ := testAuthority(t, dir, "acert-root")
+	_, distro, _ := testAuthority(t, dir, "distro-root")
+
+	store := filepath.Join(dir, "anchors")
+	testTrustStore(t, store, "")
+	if err := os.WriteFile(filepath.Join(store, "foo-2019.crt"), readFile(distro), 0644); err != nil {
+		t.Fatal(err)
+	}
+	Trust(cert)
+
+	// Certificates are recognized regardless of the source file name
+	_, renamed, _ := testAuthority(t, dir, "renamed-root")
+	source := filepath.Join(dir, "root.ca")
+	if err := os.Rename(renamed, source); err != nil {
+		t.Fatal(err)
+	}
+	Trust(source)
+
+	installed := map[string]bool{}
+	for _, c := range ListTrustedCertificates() {
+		installed[c.Subject] = c.Acert
+	}
+
+	if len(installed) != 3 || !installed["CN=acert-root"] || !installed["CN=renamed-root"] || installed["CN=distro-root"] {
+		t.Errorf("installed by acert = %v, want CN=acert-root and CN=renamed-root", installed)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// nssDatabases is a comma-delimited list of NSS database directories.
+// When empty, Firefox profiles and the shared NSS database are discovered.
+var nssDatabases string
+
+// nssProfileGlobs returns glob patterns matching directories that may contain NSS databases.
+// Firefox stores a database per profile, while Chromium on Linux uses the shared database.
+func nssProfileGlobs() []string {
+	home, _ := os.UserHomeDir()
+
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{
+			filepath.Join(home, "Library", "Application Support", "Firefox", "Profiles", "*"),
+		}
+	case "windows":
+		return []string{
+			filepath.Join(os.Getenv("APPDATA"), "Mozilla", "Firefox", "Profiles", "*"),
+		}
+	default:
+		return []string{
+			filepath.Join(home, ".pki", "nssdb"),
+			filepath.Join(home, ".mozilla", "firefox", "*"),
+			filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox", "*"),
+			filepath.Join(home, "snap", "chromium", "current", ".pki", "nssdb"),
+			filepath.Join(home, ".var", "app", "org.mozilla.firefox", ".mozilla", "firefox", "*"),
+		}
+	}
+}
+
+// nssDatabaseName returns the certutil database argument for a directory.
+// NSS uses the "sql:" prefix for cert9.db databases and "dbm:" for legacy cert8.db databases.
+func nssDatabaseName(directory string) string {
+	switch {
+	case fileExists(filepath.Join(directory, "cert9.db")):
+		return "sql:" + directory
+	case fileExists(filepath.Join(directory, "cert8.db")):
+		return "dbm:" + directory
+	default:
+		return ""
+	}
+}
+
+// findNSSDatabases returns the certutil names of the configured or discovered NSS databases.
+func findNSSDatabases() []string {
+	var databases []string
+
+	if nssDatabases != "" {
+		for _, directory := range splitValue(nssDatabases, ",") {
+			name := nssDatabaseName(directory)
+			if name == "" {
+				exit(1, "NSS database not found in directory:", directory)
+			}
+			databases = append(databases, name)
+		}
+		return databases
+	}
+
+	for _, pattern := range nssProfileGlobs() {
+		matches, _ := filepath.Glob(pattern)
+		for _, directory := range matches {
+			if name := nssDatabaseName(directory); name != "" {
+				databases = append(databases, name)
+			}
+		}
+	}
+
+	return databases
+}
+
+// findNSSCertutil returns the path to the NSS certutil command.
+// Windows ships an unrelated certutil command, so NSS is unsupported there
+// unless the databases are configured explicitly.
+func findNSSCertutil() string {
+	if runtime.GOOS == "windows" && nssDatabases == "" {
+		return ""
+	}
+
+	if command, err := exec.LookPath("certutil"); err == nil {
+		return command
+	}
+
+	// Homebrew does not link the NSS tools into the PATH
+	for _, command := range []string{"/usr/local/opt/nss/bin/certutil", "/opt/homebrew/opt/nss/bin/certutil"} {
+		if fileExists(command) {
+			return command
+		}
+	}
+
+	return ""
+}
+
+// nssNickname returns the nickname a certificate is stored under in NSS databases.
+// The nickname is derived from the certificate, so it can be found again when untrusting.
+func nssNickname(cert string) string {
+	c := parsePemCertificate(cert)
+	return fmt.Sprintf("acert %s %s", c.Subject.CommonName, certificateFingerprint(c, crypto.SHA256)[:16])
+}
+
+// requireNSS returns the certutil command and the databases to manage.
+// Missing tools are only an error when databases are configured explicitly.
+func requireNSS() (string, []string) {
+	command := findNSSCertutil()
+	if command == "" {
+		if nssDatabases != "" {
+			exit(1, "Could not find the NSS 'certutil' command (eg, install 'libnss3-tools' or 'nss')")
+		}
+		log("Skipping NSS databases: the NSS 'certutil' command was not found (eg, install 'libnss3-tools' or 'nss')")
+		return "", nil
+	}
+
+	databases := findNSSDatabases()
+	if len(databases) == 0 {
+		log("No NSS databases found")
+	}
+
+	return command, databases
+}
+
+// TrustNSS trusts a certificate authority in Firefox and Chromium NSS databases.
+// The "C,," trust flags trust the certificate to issue TLS server certificates.
+func TrustNSS(cert string) {
+	command, databases := requireNSS()
+	nickname := nssNickname(cert)
+
+	for _, database := range databases {
+		runTrustCommand(command, "-A", "-d", database, "-t", "C,,", "-n", nickname, "-i", cert)
+		if !dryRun {
+			log(fmt.Sprintf("Certificate added to NSS database '%s'", database))
+		}
+	}
+}
+
+// UntrustNSS removes a certificate from Firefox and Chromium NSS databases.
+func UntrustNSS(cert string) {
+	command, databases := requireNSS()
+	nickname := nssNickname(cert)
+
+	for _, database := range databases {
+		// Skip databases that do not contain the certificate
+		if err := exec.Command(command, "-L", "-d", database, "-n", nickname).Run(); err != nil {
+			continue
+		}
+
+		runTrustCommand(command, "-D", "-d", database, "-n", nickname)
+		if !dryRun {
+			log(fmt.Sprintf("Certificate removed from NSS database '%s'", database))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestNSSDatabaseName(t *testing.T) {
+	dir := t.TempDir()
+	if name := nssDatabaseName(dir); name != "" {
+		t.Errorf("nssDatabaseName() = %q for an empty directory, want \"\"", name)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "cert8.db"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if name := nssDatabaseName(dir); name != "dbm:"+dir {
+		t.Errorf("nssDatabaseName() = %q, want %q", name, "dbm:"+dir)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "cert9.db"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if name := nssDatabaseName(dir); name != "sql:"+dir {
+		t.Errorf("nssDatabaseName() = %q, want %q", name, "sql:"+dir)
+	}
+}
+
+func TestTrustNSS(t *testing.T) {
+	certutil := findNSSCertutil()
+	if certutil == "" {
+		t.Skip("the NSS 'certutil' command is not installed")
+	}
+
+	dir := t.TempDir()
+	if out, err := exec.Command(certutil, "-N", "-d", "sql:"+dir, "--empty-password").CombinedOutput(); err != nil {
+		t.Fatalf("could not create NSS database: %v: %s", err, out)
+	}
+	_, cert, _ := testAuthority(t, t.TempDir(), "nss-root")
+
+	nssDatabases = dir
+	t.Cleanup(func() { nssDatabases = "" })
+
+	listed := func() bool {
+		return exec.Command(certutil, "-L", "-d", "sql:"+dir, "-n", nssNickname(cert)).Run() == nil
+	}
+
+	TrustNSS(cert)
+	if !listed() {
+		t.Fatal("certificate was not added to the NSS database")
+	}
+
+	UntrustNSS(cert)
+	if listed() {
+		t.Fatal("certificate was not removed from the NSS database")
+	}
+}
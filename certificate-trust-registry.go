@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lstellway/go/command"
+)
+
+var (
+	// Registry host (and optional port) to configure trust for
+	registryHost string
+
+	// Directories containing per-registry certificate configuration
+	dockerCertsDirectory, containerdCertsDirectory string
+
+	// Private key of the trusted authority, used to issue a registry client certificate
+	registryClientKey  string
+	registryClientDays int
+)
+
+// registryHeader marks configuration files generated by acert
+const registryHeader = "# Generated by acert"
+
+// registryTrustFlags adds flags used to configure container registry trust targets
+func registryTrustFlags(h *command.CommandSection) {
+	h.StringVar(&registryHost, "registry", "", "Registry host and optional port used by the docker and containerd targets (eg, registry.test:5000)")
+	h.StringVar(&dockerCertsDirectory, "dockerCerts", "/etc/docker/certs.d", "Docker registry certificates directory")
+	h.StringVar(&containerdCertsDirectory, "containerdCerts", "/etc/containerd/certs.d", "Containerd registry hosts directory")
+	h.StringVar(&registryClientKey, "clientKey", "", "Private key of the trusted authority used to issue a registry client certificate for mTLS")
+	h.IntVar(&registryClientDays, "clientDays", 90, "Number of days the registry client certificate should be valid for")
+}
+
+// requireRegistryHost validates the configured registry host
+func requireRegistryHost() string {
+	host := strings.TrimSpace(registryHost)
+	if host == "" || strings.ContainsAny(host, "/\\") {
+		exit(1, fmt.Sprintf("Invalid value for 'registry' argument: '%s'", registryHost))
+	}
+	return host
+}
+
+// installFile writes a file to a directory that may require elevated permissions.
+// When sudo is required, the file is staged in a temporary file and installed with sudo.
+func installFile(file string, data []byte, permissions os.FileMode) {
+	if dryRun {
+		log("Would write file:", file)
+		return
+	}
+
+	if !requiresSudo() {
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		exitOnError(err, "Could not create directory:", filepath.Dir(file), err)
+		err = writeFileAtomic(file, data, permissions, -1, -1)
+		exitOnError(err, "Could not save file:", file, err)
+		log("Saved file:", file)
+		return
+	}
+
+	tmp, err := os.CreateTemp("", "acert-*")
+	exitOnError(err, err)
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	tmp.Close()
+	exitOnError(err, err)
+
+	runTrustCommand("sudo", "mkdir", "-p", filepath.Dir(file))
+	runTrustCommand("sudo", "install", "-m", fmt.Sprintf("%o", permissions), tmp.Name(), file)
+	log("Saved file:", file)
+}
+
+// removeInstalledFile removes a file that may require elevated permissions
+func removeInstalledFile(file string) {
+	if dryRun {
+		log("Would remove file:", file)
+		return
+	}
+
+	if requiresSudo() {
+		runTrustCommand("sudo", "rm", "-f", file)
+	} else {
+		err := os.Remove(file)
+		exitOnError(err, "Could not remove file:", file, err)
+	}
+	log("Removed file:", file)
+}
+
+// removeEmptyDirectory removes a directory when nothing else is stored in it
+func removeEmptyDirectory(directory string) {
+	if entries, err := os.ReadDir(directory); err != nil || len(entries) > 0 || dryRun {
+		return
+	}
+
+	if requiresSudo() {
+		runTrustCommand("sudo", "rmdir", directory)
+	} else {
+		os.Remove(directory)
+	}
+}
+
+// buildRegistryClient issues a client certificate for registry mTLS
+// signed by the trusted authority certificate and key.
+func buildRegistryClient(cert string) ([]byte, []byte) {
+	requireFileValue(&registryClientKey, "clientKey")
+	warnInsecureKeyPermissions(registryClientKey)
+
+	// The client certificate only identifies the client, so it has no host names
+	a := Acert{
+		Subject:         SubjectFields{CommonName: "acert registry client " + devAuthorityUser()}.Name(),
+		RootCertificate: *parsePemCertificate(cert),
+		RootPrivateKey:  parsePemPrivateKey(registryClientKey),
+		Options: AcertOptions{
+			Days:        registryClientDays,
+			Backdate:    defaultBackdate,
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			Algorithm:   "ecdsa-p256",
+		},
+	}
+	requireMatchingKey(&a.RootCertificate, a.RootPrivateKey, registryClientKey, cert)
+	err := a.CheckValidity()
+	exitOnError(err, "Could not issue registry client certificate:", err)
+
+	certificate := pemEncode("CERTIFICATE", a.BuildCertificate(false))
+	return certificate, pemEncode("PRIVATE KEY", privateKeyPkcs(a.PrivateKey))
+}
+
+// containerdHostsToml builds a containerd hosts.toml file for a registry.
+// Paths are absolute, as containerd does not resolve them relative to the file.
+func containerdHostsToml(directory string, host string, client bool) []byte {
+	var b strings.Builder
+	server := "https://" + host
+
+	directory, err := filepath.Abs(directory)
+	exitOnError(err, err)
+
+	fmt.Fprintf(&b, "%s\nserver = %q\n\n[host.%q]\n", registryHeader, server, server)
+	fmt.Fprintf(&b, "  ca = %q\n", filepath.Join(directory, "ca.crt"))
+	if client {
+		fmt.Fprintf(&b, "  client = [[%q, %q]]\n", filepath.Join(directory, "client.cert"), filepath.Join(directory, "client.key"))
+	}
+
+	return []byte(b.String())
+}
+
+// TrustDocker installs a certificate authority for a registry in the Docker certificates directory.
+// Docker reads "ca.crt" as well as the "client.cert" and "client.key" pair for mTLS.
+func TrustDocker(cert string) {
+	host := requireRegistryHost()
+	directory := filepath.Join(dockerCertsDirectory, host)
+
+	// The client certificate is issued first, so nothing is installed when the key does not match
+	var certificate, key []byte
+	if registryClientKey != "" {
+		certificate, key = buildRegistryClient(cert)
+	}
+
+	installFile(filepath.Join(directory, "ca.crt"), readFile(cert), 0644)
+	if certificate != nil {
+		installFile(filepath.Join(directory, "client.cert"), certificate, 0644)
+		installFile(filepath.Join(directory, "client.key"), key, 0600)
+	}
+}
+
+// TrustContainerd installs a certificate authority for a registry using the containerd hosts.toml layout
+func TrustContainerd(cert string) {
+	host := requireRegistryHost()
+	directory := filepath.Join(containerdCertsDirectory, host)
+	hostsFile := filepath.Join(directory, "hosts.toml")
+
+	if fileExists(hostsFile) && !bytes.HasPrefix(readFile(hostsFile), []byte(registryHeader)) {
+		exit(1, "Refusing to overwrite hosts.toml that was not generated by acert:", hostsFile)
+	}
+
+	// The client certificate is issued first, so nothing is installed when the key does not match
+	var certificate, key []byte
+	if registryClientKey != "" {
+		certificate, key = buildRegistryClient(cert)
+	}
+
+	installFile(filepath.Join(directory, "ca.crt"), readFile(cert), 0644)
+	if certificate != nil {
+		installFile(filepath.Join(directory, "client.cert"), certificate, 0644)
+		installFile(filepath.Join(directory, "client.key"), key, 0600)
+	}
+	installFile(hostsFile, containerdHostsToml(directory, host, registryClientKey != ""), 0644)
+}
+
+// isRegistryClient reports whether the client certificate and key in a registry directory
+// were issued by the authority, which is the case for client files generated by acert.
+func isRegistryClient(directory string, authority *x509.Certificate) bool {
+	certs, err := readPemCertificates(filepath.Join(directory, "client.cert"))
+	if err != nil || certs[0].CheckSignatureFrom(authority) != nil {
+		return false
+	}
+
+	block, _ := pem.Decode(readFileIfExists(filepath.Join(directory, "client.key")))
+	if block == nil {
+		return false
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	return err == nil && publicKeysMatch(publicKey(key), certs[0].PublicKey)
+}
+
+// untrustRegistryDirectory removes files installed for a registry.
+// The authority is only removed if it matches the certificate,
+// and client files are only removed along with it when the authority issued them.
+func untrustRegistryDirectory(cert string, directory string) {
+	file := filepath.Join(directory, "ca.crt")
+	authority := parsePemCertificate(cert)
+
+	if !bundleContains(readFileIfExists(file), certificateFingerprint(authority, crypto.SHA256)) {
+		log("Certificate not found in registry directory:", directory)
+		return
+	}
+
+	isClient := isRegistryClient(directory, authority)
+	removeInstalledFile(file)
+	for _, name := range []string{"client.cert", "client.key"} {
+		if !fileExists(filepath.Join(directory, name)) {
+			continue
+		}
+		if !isClient {
+			log("Keeping client file that was not issued by the certificate authority:", filepath.Join(directory, name))
+			continue
+		}
+		removeInstalledFile(filepath.Join(directory, name))
+	}
+
+	hostsFile := filepath.Join(directory, "hosts.toml")
+	if bytes.HasPrefix(readFileIfExists(hostsFile), []byte(registryHeader)) {
+		removeInstalledFile(hostsFile)
+	}
+
+	removeEmptyDirectory(directory)
+}
+
+// UntrustDocker removes a registry certificate authority from the Docker certificates directory
+func UntrustDocker(cert string) {
+	untrustRegistryDirectory(cert, filepath.Join(dockerCertsDirectory, requireRegistryHost()))
+}
+
+// UntrustContainerd removes a registry certificate authority from the containerd hosts directory
+func UntrustContainerd(cert string) {
+	untrustRegistryDirectory(cert, filepath.Join(containerdCertsDirectory, requireRegistryHost()))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testRegistry configures registry trust targets to use temporary directories.
+func testRegistry(t *testing.T, clientKey string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	registryHost, registryClientKey, registryClientDays = "registry.test:5000", clientKey, 30
+	dockerCertsDirectory, containerdCertsDirectory = filepath.Join(dir, "docker"), filepath.Join(dir, "containerd")
+	trustSudo = false
+	t.Cleanup(func() {
+		registryHost, registryClientKey, trustSudo = "", "", true
+	})
+
+	return filepath.Join(dockerCertsDirectory, registryHost)
+}
+
+func TestTrustDockerClient(t *testing.T) {
+	_, cert, key := testAuthority(t, t.TempDir(), "registry-root")
+	directory := testRegistry(t, key)
+
+	TrustDocker(cert)
+
+	client := testCertificate(t, filepath.Join(directory, "client.cert"))
+	if !reflect.DeepEqual(client.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}) {
+		t.Errorf("extended key usage = %v, want clientAuth only", client.ExtKeyUsage)
+	}
+	if len(client.DNSNames) > 0 || len(client.IPAddresses) > 0 {
+		t.Errorf("client certificate has subject alternative names %v %v", client.DNSNames, client.IPAddresses)
+	}
+	if !isRegistryClient(directory, testCertificate(t, cert)) {
+		t.Error("client certificate was not issued by the authority")
+	}
+
+	UntrustDocker(cert)
+	if fileExists(directory) {
+		t.Error("registry directory was not removed")
+	}
+}
+
+func TestUntrustDockerKeepsClient(t *testing.T) {
+	dir := t.TempDir()
+	_, cert, _ := testAuthority(t, dir, "registry-root")
+	_, other, otherKey := testAuthority(t, dir, "other-root")
+	directory := testRegistry(t, "")
+
+	// Client files issued by another authority are kept
+	TrustDocker(cert)
+	for file, source := range map[string]string{"client.cert": other, "client.key": otherKey} {
+		if err := os.WriteFile(filepath.Join(directory, file), readFile(source), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	UntrustDocker(cert)
+	if fileExists(filepath.Join(directory, "ca.crt")) {
+		t.Error("authority was not removed")
+	}
+	for _, file := range []string{"client.cert", "client.key"} {
+		if !fileExists(filepath.Join(directory, file)) {
+			t.Errorf("%s was removed", file)
+		}
+	}
+}
+
+func TestTrustContainerd(t *testing.T) {
+	_, cert, key := testAuthority(t, t.TempDir(), "registry-root")
+	testRegistry(t, key)
+	directory := filepath.Join(containerdCertsDirectory, registryHost)
+
+	TrustContainerd(cert)
+	for _, file := range []string{"ca.crt", "client.cert", "client.key", "hosts.toml"} {
+		if !fileExists(filepath.Join(directory, file)) {
+			t.Errorf("%s was not saved", file)
+		}
+	}
+
+	UntrustContainerd(cert)
+	if fileExists(directory) {
+		t.Error("registry directory was not removed")
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/lstellway/go/command"
+)
+
+var (
+	// Java keystore options
+	javaKeystore, javaStorepass string
+
+	// Python virtual environment containing the certifi package
+	pythonVirtualenv string
+
+	// Bundle file used with the NODE_EXTRA_CA_CERTS environment variable
+	nodeBundle string
+)
+
+// runtimeTrustFlags adds flags used to configure language runtime trust targets
+func runtimeTrustFlags(h *command.CommandSection) {
+	h.StringVar(&javaKeystore, "javaKeystore", "", "Path to the Java 'cacerts' keystore (defaults to the keystore in JAVA_HOME)")
+	h.StringVar(&javaStorepass, "javaStorepass", "changeit", "Password of the Java keystore")
+	h.StringVar(&pythonVirtualenv, "venv", os.Getenv("VIRTUAL_ENV"), "Path to the Python virtual environment containing the 'certifi' package")
+	h.StringVar(&nodeBundle, "nodeBundle", filepath.Join(defaultDevHome(), "node-extra-ca-certs.pem"), "Path to the certificate bundle used with NODE_EXTRA_CA_CERTS")
+}
+
+// runtimeAlias returns the alias a certificate is stored under in a Java keystore.
+func runtimeAlias(c *x509.Certificate) string {
+	return fmt.Sprintf("acert-%s", certificateFingerprint(c, crypto.SHA256)[:16])
+}
+
+// findKeytool returns the path to the Java keytool command
+func findKeytool() string {
+	if home := os.Getenv("JAVA_HOME"); home != "" {
+		for _, name := range []string{"keytool", "keytool.exe"} {
+			if command := filepath.Join(home, "bin", name); fileExists(command) {
+				return command
+			}
+		}
+	}
+
+	command, err := exec.LookPath("keytool")
+	exitOnError(err, "Could not find the Java 'keytool' command (set JAVA_HOME or add it to PATH)")
+	return command
+}
+
+// findJavaKeystore returns the configured Java keystore or the default 'cacerts' keystore.
+// Java 9+ stores it in lib/security, while Java 8 uses jre/lib/security.
+func findJavaKeystore() string {
+	if javaKeystore != "" {
+		requireFileValue(&javaKeystore, "javaKeystore")
+		return javaKeystore
+	}
+
+	if home := os.Getenv("JAVA_HOME"); home != "" {
+		for _, file := range []string{
+			filepath.Join(home, "lib", "security", "cacerts"),
+			filepath.Join(home, "jre", "lib", "security", "cacerts"),
+		} {
+			if fileExists(file) {
+				return file
+			}
+		}
+	}
+
+	exit(1, "Java keystore not found. Specify one with the 'javaKeystore' argument or set JAVA_HOME.")
+	return ""
+}
+
+// findCertifiBundle returns the certifi bundle of the configured virtual environment.
+func findCertifiBundle() string {
+	if pythonVirtualenv == "" {
+		exit(1, "Python virtual environment not set. Specify one with the 'venv' argument or activate it.")
+	}
+
+	for _, pattern := range []string{
+		filepath.Join(pythonVirtualenv, "lib", "python*", "site-packages", "certifi", "cacert.pem"),
+		filepath.Join(pythonVirtualenv, "Lib", "site-packages", "certifi", "cacert.pem"),
+	} {
+		if matches, _ := filepath.Glob(pattern); len(matches) > 0 {
+			return matches[0]
+		}
+	}
+
+	exit(1, "The 'certifi' package was not found in virtual environment:", pythonVirtualenv)
+	return ""
+}
+
+// bundleContains reports whether a PEM bundle contains a certificate
+func bundleContains(data []byte, fingerprint string) bool {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return false
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if c, err := x509.ParseCertificate(block.Bytes); err == nil && certificateFingerprint(c, crypto.SHA256) == fingerprint {
+			return true
+		}
+	}
+}
+
+// saveBundle writes a PEM bundle, keeping the permissions of an existing file
+func saveBundle(file string, data []byte) {
+	permissions := os.FileMode(0644)
+	if info, err := os.Stat(file); err == nil {
+		permissions = info.Mode().Perm()
+	}
+
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	exitOnError(err, "Could not create directory:", filepath.Dir(file), err)
+	err = writeFileAtomic(file, data, permissions, -1, -1)
+	exitOnError(err, "Could not save file:", file, err)
+}
+
+// AddToBundle appends a certificate to a PEM bundle if it is not already included.
+// A comment identifying the certificate is written before the PEM block.
+func AddToBundle(file string, cert string) {
+	c := parsePemCertificate(cert)
+	fingerprint := certificateFingerprint(c, crypto.SHA256)
+
+	var data []byte
+	if fileExists(file) {
+		data = readFile(file)
+	}
+
+	if bundleContains(data, fingerprint) {
+		log("Certificate already included in bundle:", file)
+		return
+	}
+	if dryRun {
+		log("Would add certificate to bundle:", file)
+		return
+	}
+
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	data = append(data, fmt.Sprintf("\n# acert: %s\n# SHA-256: %s\n", c.Subject.String(), fingerprint)...)
+	data = append(data, pemEncode("CERTIFICATE", c.Raw)...)
+
+	saveBundle(file, data)
+	log("Certificate added to bundle:", file)
+}
+
+// commentStart returns the offset of the comment lines preceding
+// the last PEM block in a segment of a bundle.
+func commentStart(segment []byte) int {
+	start := bytes.LastIndex(segment, []byte("-----BEGIN"))
+
+	for start > 0 {
+		previous := bytes.LastIndexByte(segment[:start-1], '\n') + 1
+		line := bytes.TrimSpace(segment[previous:start])
+		if len(line) > 0 && line[0] != '#' {
+			break
+		}
+		start = previous
+	}
+
+	return start
+}
+
+// RemoveFromBundle removes a certificate from a PEM bundle.
+// Comment lines preceding a matching PEM block are removed with it.
+func RemoveFromBundle(file string, cert string) {
+	if !fileExists(file) {
+		return
+	}
+
+	fingerprint := certificateFingerprint(parsePemCertificate(cert), crypto.SHA256)
+	data := readFile(file)
+
+	var (
+		result  []byte
+		removed int
+		rest    = data
+	)
+
+	for {
+		block, next := pem.Decode(rest)
+		if block == nil {
+			result = append(result, rest...)
+			break
+		}
+
+		segment := rest[:len(rest)-len(next)]
+		rest = next
+
+		if block.Type == "CERTIFICATE" {
+			if c, err := x509.ParseCertificate(block.Bytes); err == nil && certificateFingerprint(c, crypto.SHA256) == fingerprint {
+				removed++
+				result = append(result, segment[:commentStart(segment)]...)
+				continue
+			}
+		}
+		result = append(result, segment...)
+	}
+
+	if removed == 0 {
+		log("Certificate not found in bundle:", file)
+		return
+	}
+	if dryRun {
+		log("Would remove certificate from bundle:", file)
+		return
+	}
+
+	saveBundle(file, result)
+	log("Certificate removed from bundle:", file)
+}
+
+// TrustJava imports a certificate into a Java keystore
+func TrustJava(cert string) {
+	keytool, keystore := findKeytool(), findJavaKeystore()
+	alias := runtimeAlias(parsePemCertificate(cert))
+
+	runTrustCommand(keytool, "-importcert", "-noprompt", "-trustcacerts", "-keystore", keystore, "-storepass", javaStorepass, "-alias", alias, "-file", cert)
+	if !dryRun {
+		log(fmt.Sprintf("Certificate imported into Java keystore '%s'", keystore))
+	}
+}
+
+// UntrustJava removes a certificate from a Java keystore
+func UntrustJava(cert string) {
+	keytool, keystore := findKeytool(), findJavaKeystore()
+	alias := runtimeAlias(parsePemCertificate(cert))
+
+	// Skip keystores that do not contain the certificate
+	if err := exec.Command(keytool, "-list", "-keystore", keystore, "-storepass", javaStorepass, "-alias", alias).Run(); err != nil {
+		log("Certificate not found in Java keystore:", keystore)
+		return
+	}
+
+	runTrustCommand(keytool, "-delete", "-keystore", keystore, "-storepass", javaStorepass, "-alias", alias)
+	if !dryRun {
+		log(fmt.Sprintf("Certificate removed from Java keystore '%s'", keystore))
+	}
+}
+
+// TrustPython adds a certificate to the certifi bundle of a Python virtual environment
+func TrustPython(cert string) {
+	AddToBundle(findCertifiBundle(), cert)
+}
+
+// UntrustPython removes a certificate from the certifi bundle of a Python virtual environment
+func UntrustPython(cert string) {
+	RemoveFromBundle(findCertifiBundle(), cert)
+}
+
+// TrustNode adds a certificate to the bundle used with NODE_EXTRA_CA_CERTS
+func TrustNode(cert string) {
+	AddToBundle(nodeBundle, cert)
+	log(fmt.Sprintf("Configure Node.js to use the bundle with: export NODE_EXTRA_CA_CERTS=\"%s\"", nodeBundle))
+}
+
+// UntrustNode removes a certificate from the bundle used with NODE_EXTRA_CA_CERTS
+func UntrustNode(cert string) {
+	RemoveFromBundle(nodeBundle, cert)
+}
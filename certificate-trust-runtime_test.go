@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommentStart(t *testing.T) {
+	block := "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+
+	tests := []struct {
+		name    string
+		segment string
+		want    string
+	}{
+		{"no comments", block, block},
+		{"comments", "# acert: CN=root\n# SHA-256: AB\n" + block, "# acert: CN=root\n# SHA-256: AB\n" + block},
+		{"blank line before comments", "\n# acert: CN=root\n" + block, "\n# acert: CN=root\n" + block},
+		{"text before comments", "Bundle header\n# acert: CN=root\n" + block, "# acert: CN=root\n" + block},
+		{"text before block", "Bundle header\n" + block, block},
+		{"indented comments", "Bundle header\n  # acert: CN=root\n" + block, "  # acert: CN=root\n" + block},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segment := []byte(tt.segment)
+			if got := string(segment[commentStart(segment):]); got != tt.want {
+				t.Errorf("commentStart() removes %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBundle(t *testing.T) {
+	dir := t.TempDir()
+	_, cert, _ := testAuthority(t, dir, "bundle-root")
+	_, other, _ := testAuthority(t, dir, "other-root")
+
+	// Existing bundles keep their contents and permissions
+	bundle := filepath.Join(dir, "cacert.pem")
+	original := append([]byte("# Existing bundle\n"), readFile(other)...)
+	if err := os.WriteFile(bundle, original, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	AddToBundle(bundle, cert)
+	data := readFile(bundle)
+	if !bundleContains(data, certificateFingerprint(testCertificate(t, cert), crypto.SHA256)) {
+		t.Fatal("certificate was not added to the bundle")
+	}
+	if !bytes.HasPrefix(data, original) {
+		t.Error("existing bundle contents were changed")
+	}
+	if !strings.Contains(string(data), "# acert: CN=bundle-root") {
+		t.Error("certificate comment was not added to the bundle")
+	}
+	if info, err := os.Stat(bundle); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("bundle permissions = %v, want 0640", info.Mode().Perm())
+	}
+
+	// Certificates are only added once
+	AddToBundle(bundle, cert)
+	if !bytes.Equal(readFile(bundle), data) {
+		t.Error("certificate was added to the bundle twice")
+	}
+
+	// Removing the certificate restores the original bundle
+	RemoveFromBundle(bundle, cert)
+	if got := readFile(bundle); !bytes.Equal(got, original) {
+		t.Errorf("bundle after removal = %q, want %q", got, original)
+	}
+
+	// Missing bundles are ignored
+	RemoveFromBundle(filepath.Join(dir, "missing.pem"), cert)
+}
+
+func TestBundleDryRun(t *testing.T) {
+	dir := t.TempDir()
+	_, cert, _ := testAuthority(t, dir, "bundle-root")
+	bundle := filepath.Join(dir, "node-extra-ca-certs.pem")
+
+	dryRun = true
+	t.Cleanup(func() { dryRun = false })
+
+	AddToBundle(bundle, cert)
+	if fileExists(bundle) {
+		t.Error("bundle was created during a dry run")
+	}
+}
@@ -1,61 +1,237 @@
 package main
 
 import (
+	"crypto"
 	"fmt"
+	"os"
 	"os/exec"
 	"path"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/lstellway/go/command"
 )
 
+var (
+	// Custom trust store directory and update command (eg, container images or chroots)
+	trustStore, trustUpdateCommand string
+
+	// Run privileged trust store commands with sudo
+	trustSudo = true
+)
+
+// requiresSudo reports whether trust store commands are run with sudo.
+// Sudo is not used when disabled or when already running as root.
+func requiresSudo() bool {
+	return trustSudo && runtime.GOOS != "windows" && os.Geteuid() != 0
+}
+
+// privileged prefixes a command with sudo when required
+func privileged(command ...string) []string {
+	if requiresSudo() {
+		return append([]string{"sudo"}, command...)
+	}
+	return command
+}
+
 // TrustDarwin trust a PKI certificate on macOS (Darwin)
 func TrustDarwin(cert string) {
-	cmd := exec.Command("sudo", "security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", "/Library/Keychains/System.keychain", cert)
+	command := privileged("security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", "/Library/Keychains/System.keychain", cert)
+	cmd := exec.Command(command[0], command[1:]...)
 	err := cmd.Run()
 	exitOnError(err)
 }
 
-// TrustLinux trust a PKI certificate on Linux
-func TrustLinux(cert string) {
-	var (
-		command []string
-		file    string
-	)
+// LinuxTrustStore describes a Linux certificate trust store layout
+type LinuxTrustStore struct {
+	Directory string   // Directory containing trust anchors
+	Extension string   // File extension expected by the update command
+	Command   []string // Command used to update the system trust store
+}
 
-	switch {
-	case fileExists("/etc/pki/ca-trust/source/anchors/"):
-		command = []string{"update-ca-trust", "extract"}
-		file = "/etc/pki/ca-trust/source/anchors/%s-%d.pem"
-	case fileExists("/usr/local/share/ca-certificates/"):
-		command = []string{"update-ca-certificates"}
-		file = "/usr/local/share/ca-certificates/%s-%d.crt"
-	case fileExists("/etc/ca-certificates/trust-source/anchors/"):
-		command = []string{"trust", "extract-compat"}
-		file = "/etc/ca-certificates/trust-source/anchors/%s-%d.crt"
-	case fileExists("/usr/share/pki/trust/anchors/"):
-		command = []string{"update-ca-certificates"}
-		file = "/usr/share/pki/trust/anchors/%s-%d.pem"
-	default:
-		exit(1, "Supported certificate management not found.")
+// linuxTrustStores lists supported Linux trust store layouts in order of preference
+var linuxTrustStores = []LinuxTrustStore{
+	{"/etc/pki/ca-trust/source/anchors/", ".pem", []string{"update-ca-trust", "extract"}},
+	{"/usr/local/share/ca-certificates/", ".crt", []string{"update-ca-certificates"}},
+	{"/etc/ca-certificates/trust-source/anchors/", ".crt", []string{"trust", "extract-compat"}},
+	{"/usr/share/pki/trust/anchors/", ".pem", []string{"update-ca-certificates"}},
+}
+
+// customTrustStore builds a trust store layout for the configured store directory.
+// When the directory ends with a known layout (eg, an image root filesystem),
+// the extension and update command of that layout are used by default.
+func customTrustStore() LinuxTrustStore {
+	requireFileValue(&trustStore, "store")
+
+	store := LinuxTrustStore{Directory: trustStore, Extension: ".crt"}
+	for _, known := range linuxTrustStores {
+		if strings.HasSuffix(path.Clean(trustStore), path.Clean(known.Directory)) {
+			store.Extension = known.Extension
+			break
+		}
+	}
+
+	// The update command is run by the shell, so it can contain quoted arguments
+	if strings.TrimSpace(trustUpdateCommand) != "" {
+		store.Command = []string{"sh", "-c", trustUpdateCommand}
+	}
+	return store
+}
+
+// configuredLinuxTrustStores returns the configured store or the supported layouts
+func configuredLinuxTrustStores() []LinuxTrustStore {
+	if trustStore != "" {
+		return []LinuxTrustStore{customTrustStore()}
 	}
+	return linuxTrustStores
+}
+
+// findLinuxTrustStore returns the configured store or
+// the first trust store layout found on the system
+func findLinuxTrustStore() LinuxTrustStore {
+	if trustStore != "" {
+		return customTrustStore()
+	}
+
+	for _, store := range linuxTrustStores {
+		if fileExists(store.Directory) {
+			return store
+		}
+	}
+
+	exit(1, "Supported certificate management not found.")
+	return LinuxTrustStore{}
+}
+
+// TrustLinux trust a PKI certificate on Linux
+func TrustLinux(cert string) {
+	store := findLinuxTrustStore()
 
 	// Build file path
-	file = fmt.Sprintf(file, path.Base(cert), time.Now().Unix())
+	file := path.Join(store.Directory, fmt.Sprintf("%s-%d%s", path.Base(cert), time.Now().Unix(), store.Extension))
 
 	// Copy certificate
-	cmd := exec.Command("sudo", "cp", cert, file)
-	err := cmd.Run()
-	exitOnError(err, err)
-	fmt.Printf("Certificate copied to '%s'", file)
+	if requiresSudo() {
+		runTrustCommand("sudo", "cp", cert, file)
+	} else {
+		err := writeFileAtomic(file, readFile(cert), 0644, -1, -1)
+		exitOnError(err, "Could not save file:", file, err)
+	}
+	log(fmt.Sprintf("Certificate copied to '%s'", file))
 
 	// Trust certificate
-	cmd = exec.Command("sudo", command...)
-	err = cmd.Run()
+	if len(store.Command) > 0 {
+		runTrustCommand(privileged(store.Command...)...)
+	}
+}
+
+// runTrustCommand runs a command used to manage a trust store.
+// When performing a dry run, the command is printed instead.
+func runTrustCommand(command ...string) {
+	if dryRun {
+		log("Would run:", strings.Join(command, " "))
+		return
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	err := cmd.Run()
 	exitOnError(err, err)
 }
 
+// UntrustDarwin removes a trusted PKI certificate on macOS (Darwin)
+func UntrustDarwin(cert string) {
+	fingerprint := certificateFingerprint(parsePemCertificate(cert), crypto.SHA1)
+	runTrustCommand(privileged("security", "remove-trusted-cert", "-d", cert)...)
+	runTrustCommand(privileged("security", "delete-certificate", "-Z", fingerprint, "/Library/Keychains/System.keychain")...)
+}
+
+// findLinuxAnchors returns anchor files in a trust store directory
+// containing only the certificate with the specified SHA-256 fingerprint.
+// Bundles that also contain other certificates are kept.
+func findLinuxAnchors(directory string, fingerprint string) []string {
+	var matches []string
+
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return matches
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		file := path.Join(directory, entry.Name())
+		certs, err := readPemCertificates(file)
+		if err != nil {
+			continue
+		}
+
+		for _, c := range certs {
+			if certificateFingerprint(c, crypto.SHA256) != fingerprint {
+				continue
+			}
+			if len(certs) == 1 {
+				matches = append(matches, file)
+			} else {
+				log("Keeping bundle containing other certificates:", file)
+			}
+			break
+		}
+	}
+
+	return matches
+}
+
+// UntrustLinux removes anchors matching the certificate fingerprint
+// from every supported trust store layout found on the system.
+func UntrustLinux(cert string) {
+	fingerprint := certificateFingerprint(parsePemCertificate(cert), crypto.SHA256)
+	found := false
+
+	for _, store := range configuredLinuxTrustStores() {
+		matches := findLinuxAnchors(store.Directory, fingerprint)
+		if len(matches) == 0 {
+			continue
+		}
+		found = true
+
+		// Remove certificates
+		for _, file := range matches {
+			if dryRun {
+				log("Would remove anchor:", file)
+				continue
+			}
+			if requiresSudo() {
+				runTrustCommand("sudo", "rm", "-f", file)
+			} else {
+				err := os.Remove(file)
+				exitOnError(err, "Could not remove file:", file, err)
+			}
+			log("Removed anchor:", file)
+		}
+
+		// Update trust store
+		if len(store.Command) > 0 {
+			runTrustCommand(privileged(store.Command...)...)
+		}
+	}
+
+	if !found {
+		log("No trusted anchors found for certificate:", cert)
+	}
+}
+
+// UntrustWindows removes a trusted PKI certificate on Windows
+func UntrustWindows(cert string) {
+	command, err := exec.LookPath("certutil")
+	exitOnError(err, "Could not find 'certutil' command")
+
+	fingerprint := certificateFingerprint(parsePemCertificate(cert), crypto.SHA1)
+	runTrustCommand(command, "-delstore", "ROOT", fingerprint)
+}
+
 // TrustWindows trust a PKI certificate on Windows
 func TrustWindows(cert string) {
 	command, err := exec.LookPath("certutil")
@@ -73,37 +249,171 @@ func Trust(cert string) {
 	requireFileValue(&cert, "certificate")
 
 	// Execute trust strategy based on OS
-	switch runtime.GOOS {
-	case "darwin":
+	switch {
+	case trustStore != "":
+		TrustLinux(cert)
+	case runtime.GOOS == "darwin":
 		TrustDarwin(cert)
-	case "linux":
+	case runtime.GOOS == "linux":
 		TrustLinux(cert)
-	case "windows":
+	case runtime.GOOS == "windows":
 		TrustWindows(cert)
 	default:
 		exit(1, fmt.Sprintf("The operating system '%s' is currently unsupported.\n", runtime.GOOS))
 	}
 }
 
+// Untrust removes a PKI certificate from the system trust store.
+// The method used is determined based on the operating system.
+func Untrust(cert string) {
+	requireFileValue(&cert, "certificate")
+
+	// Execute untrust strategy based on OS
+	switch {
+	case trustStore != "":
+		UntrustLinux(cert)
+	case runtime.GOOS == "darwin":
+		UntrustDarwin(cert)
+	case runtime.GOOS == "linux":
+		UntrustLinux(cert)
+	case runtime.GOOS == "windows":
+		UntrustWindows(cert)
+	default:
+		exit(1, fmt.Sprintf("The operating system '%s' is currently unsupported.\n", runtime.GOOS))
+	}
+}
+
+// trustTargetFlags adds flags used to select the trust stores to manage
+func trustTargetFlags(h *command.CommandSection) {
+	h.StringVar(&trustTarget, "target", trustTarget, "Comma-delimited trust stores to manage (system, nss, java, python, node, docker, containerd)")
+	h.StringVar(&trustStore, "store", "", "Directory to install system trust anchors into instead of the host trust store (eg, an image root filesystem)")
+	h.StringVar(&trustUpdateCommand, "update-cmd", "", "Shell command used to update the trust store configured with the 'store' argument")
+	h.BoolVar(&trustSudo, "sudo", trustSudo, "Use sudo to modify system trust stores")
+	h.StringVar(&nssDatabases, "nssdb", "", "Comma-delimited NSS database directories (defaults to Firefox profiles and the shared NSS database)")
+	runtimeTrustFlags(h)
+	registryTrustFlags(h)
+}
+
+// trustTargets returns the configured trust store targets.
+// Unknown targets cause the program to exit before any changes are made.
+func trustTargets() []string {
+	targets := splitValue(strings.ToLower(trustTarget), ",")
+	for _, target := range targets {
+		switch target {
+		case "system", "nss", "java", "python", "node", "docker", "containerd":
+		default:
+			exit(1, fmt.Sprintf("Unknown trust target '%s'", target))
+		}
+	}
+	return targets
+}
+
+// TrustTargets trusts a certificate in each of the configured trust stores.
+func TrustTargets(cert string) {
+	for _, target := range trustTargets() {
+		switch target {
+		case "system":
+			if requiresSudo() {
+				log("Sudo permissions are required to trust certificates")
+			}
+			Trust(cert)
+		case "nss":
+			TrustNSS(cert)
+		case "java":
+			TrustJava(cert)
+		case "python":
+			TrustPython(cert)
+		case "node":
+			TrustNode(cert)
+		case "docker":
+			TrustDocker(cert)
+		case "containerd":
+			TrustContainerd(cert)
+		}
+	}
+}
+
+// UntrustTargets removes a certificate from each of the configured trust stores.
+func UntrustTargets(cert string) {
+	for _, target := range trustTargets() {
+		switch target {
+		case "system":
+			if requiresSudo() && !dryRun {
+				log("Sudo permissions are required to untrust certificates")
+			}
+			Untrust(cert)
+		case "nss":
+			UntrustNSS(cert)
+		case "java":
+			UntrustJava(cert)
+		case "python":
+			UntrustPython(cert)
+		case "node":
+			UntrustNode(cert)
+		case "docker":
+			UntrustDocker(cert)
+		case "containerd":
+			UntrustContainerd(cert)
+		}
+	}
+}
+
 // trustCertificate defines the CLI command to trust a PKI certificate.
 func trustCertificates(flags ...string) {
 	// Initialize command
-	cmd, args = command.NewCommand(commandName("trust"), "Trust PKI certificates", func(h *command.Command) {
+	cmd, args = newCommand(commandName("trust"), "Trust PKI certificates", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			trustTargetFlags(s)
+		})
+
 		h.AddArgument("CERTIFICATE_FILES...")
 
 		h.AddExample("Trust a single certificate", "test.com.csr.pem")
 		h.AddExample("Trust multiple certificates", "local-root.ca.cert.pem remote.ca.cert.pem test.com.csr.pem")
+		h.AddExample("Trust a certificate in Firefox and Chromium only", "-target nss local-root.ca.cert.pem")
+		h.AddExample("Trust a certificate in a container image root filesystem", "-sudo=false -store rootfs/usr/local/share/ca-certificates -update-cmd 'chroot rootfs update-ca-certificates' local-root.ca.cert.pem")
+		h.AddExample("Trust a registry certificate authority and issue an mTLS client certificate", "-target docker,containerd -registry registry.test:5000 -clientKey local-root.ca.key.pem local-root.ca.cert.pem")
+		h.AddExample("Trust a certificate in language runtimes", "-target java,python,node -venv .venv local-root.ca.cert.pem")
 
+		h.AddSubcommand("list", "List certificates in the system trust store")
 		h.AddSubcommand("help", "Display this help screen")
 	}, flags...)
 
-	switch getArgument(true) {
+	switch getArgument(false) {
 	case "", "help":
 		cmd.Usage()
+	case "list":
+		trustListCommand(args[1:]...)
 	default:
-		log("Sudo permissions are required to trust certificates")
-		for _, cert := range flags {
-			Trust(cert)
+		for _, cert := range args {
+			TrustTargets(cert)
+		}
+	}
+}
+
+// untrustCertificates defines the CLI command to remove PKI certificates from trust stores.
+func untrustCertificates(flags ...string) {
+	// Initialize command
+	cmd, args = newCommand(commandName("untrust"), "Remove PKI certificates from trust stores", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			trustTargetFlags(s)
+			s.BoolVar(&dryRun, "dry-run", false, "Print the changes that would be made without making them")
+		})
+
+		h.AddArgument("CERTIFICATE_FILES...")
+
+		h.AddExample("Untrust a certificate", "local-root.ca.cert.pem")
+		h.AddExample("Show what would be removed", "-dry-run local-root.ca.cert.pem")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	switch getArgument(false) {
+	case "", "help":
+		cmd.Usage()
+	default:
+		for _, cert := range args {
+			UntrustTargets(cert)
 		}
 	}
 }
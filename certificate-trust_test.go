@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testTrustStore configures a custom trust store directory that does not require sudo.
+func testTrustStore(t *testing.T, directory string, updateCommand string) {
+	t.Helper()
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	trustStore, trustUpdateCommand, trustSudo = directory, updateCommand, false
+	t.Cleanup(func() {
+		trustStore, trustUpdateCommand, trustSudo = "", "", true
+	})
+}
+
+func TestCustomTrustStore(t *testing.T) {
+	tests := []struct {
+		directory string
+		extension string
+	}{
+		{"anchors", ".crt"},
+		{"rootfs/etc/pki/ca-trust/source/anchors", ".pem"},
+		{"rootfs/usr/local/share/ca-certificates", ".crt"},
+		{"rootfs/usr/share/pki/trust/anchors/", ".pem"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.directory, func(t *testing.T) {
+			testTrustStore(t, filepath.Join(t.TempDir(), tt.directory), "update-ca-certificates --fresh")
+
+			store := customTrustStore()
+			if store.Extension != tt.extension {
+				t.Errorf("extension = %q, want %q", store.Extension, tt.extension)
+			}
+			if !reflect.DeepEqual(store.Command, []string{"sh", "-c", "update-ca-certificates --fresh"}) {
+				t.Errorf("command = %q, want the configured update command run by the shell", store.Command)
+			}
+		})
+	}
+}
+
+func TestTrustCustomStore(t *testing.T) {
+	dir := t.TempDir()
+	_, cert, _ := testAuthority(t, dir, "store-root")
+	_, other, _ := testAuthority(t, dir, "other-root")
+
+	store := filepath.Join(dir, "rootfs", "usr", "local", "share", "ca-certificates")
+	updated := filepath.Join(dir, "updated")
+	testTrustStore(t, store, "touch "+updated)
+
+	// Anchors that were not installed for the certificate are kept
+	unrelated := filepath.Join(store, "other-root.crt")
+	if err := os.WriteFile(unrelated, readFile(other), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	Trust(cert)
+	anchors, _ := filepath.Glob(filepath.Join(store, filepath.Base(cert)+"-*.crt"))
+	if len(anchors) != 1 {
+		t.Fatalf("anchors = %v, want 1 anchor", anchors)
+	}
+	if !fileExists(updated) {
+		t.Error("update command was not run after trusting")
+	}
+
+	// Dry runs do not change the store
+	os.Remove(updated)
+	dryRun = true
+	Untrust(cert)
+	dryRun = false
+	if !fileExists(anchors[0]) || fileExists(updated) {
+		t.Error("store was changed during a dry run")
+	}
+
+	Untrust(cert)
+	if fileExists(anchors[0]) {
+		t.Error("anchor was not removed")
+	}
+	if !fileExists(unrelated) {
+		t.Error("unrelated anchor was removed")
+	}
+	if !fileExists(updated) {
+		t.Error("update command was not run after untrusting")
+	}
+}
+
+func TestUntrustKeepsBundles(t *testing.T) {
+	dir := t.TempDir()
+	_, cert, _ := testAuthority(t, dir, "store-root")
+	_, other, _ := testAuthority(t, dir, "other-root")
+
+	store := filepath.Join(dir, "anchors")
+	testTrustStore(t, store, "")
+
+	// Bundles containing the certificate along with other authorities are kept
+	bundle := filepath.Join(store, "bundle.crt")
+	data := append(append(readFile(other), '\n'), readFile(cert)...)
+	if err := os.WriteFile(bundle, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	copied := filepath.Join(store, "copied.crt")
+	if err := os.WriteFile(copied, readFile(cert), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	Untrust(cert)
+	if fileExists(copied) {
+		t.Error("anchor containing only the certificate was not removed")
+	}
+	if saved, err := os.ReadFile(bundle); err != nil || string(saved) != string(data) {
+		t.Errorf("bundle was changed: %v", err)
+	}
+}
+
+func TestTrustCustomStoreQuotedCommand(t *testing.T) {
+	dir := t.TempDir()
+	_, cert, _ := testAuthority(t, dir, "store-root")
+
+	// Update commands can use quoted paths containing spaces
+	updated := filepath.Join(dir, "update dir", "updated file")
+	if err := os.MkdirAll(filepath.Dir(updated), 0755); err != nil {
+		t.Fatal(err)
+	}
+	testTrustStore(t, filepath.Join(dir, "anchors"), "touch '"+updated+"'")
+
+	Trust(cert)
+	if !fileExists(updated) {
+		t.Errorf("update command did not create %s", updated)
+	}
+
+	// Without an update command, only the anchor is installed
+	testTrustStore(t, filepath.Join(dir, "other"), " ")
+	if store := customTrustStore(); len(store.Command) != 0 {
+		t.Errorf("command = %q, want none", store.Command)
+	}
+}
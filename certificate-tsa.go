@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/digitorus/pkcs7"
+	"github.com/digitorus/timestamp"
+	"github.com/lstellway/go/command"
+)
+
+// Media types used by RFC 3161 time-stamp requests and responses over HTTP
+// https://datatracker.ietf.org/doc/html/rfc3161#section-3.4
+const (
+	timestampQueryType = "application/timestamp-query"
+	timestampReplyType = "application/timestamp-reply"
+)
+
+// Policy used in time-stamp tokens when a policy is not configured (anyPolicy)
+var defaultTimestampPolicy = asn1.ObjectIdentifier{2, 5, 29, 32, 0}
+
+// RequestTimestamp requests an RFC 3161 time-stamp token for data from a time-stamp authority.
+// The token is returned in DER form along with its parsed contents.
+func RequestTimestamp(url string, data []byte) ([]byte, *timestamp.Timestamp, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	request, err := timestamp.CreateRequest(bytes.NewReader(data), &timestamp.RequestOptions{
+		Hash:         crypto.SHA256,
+		Certificates: true,
+		Nonce:        nonce,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Post(url, timestampQueryType, bytes.NewReader(request))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("time-stamp authority responded with %s", response.Status)
+	}
+
+	ts, err := timestamp.ParseResponse(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ts.Nonce == nil || ts.Nonce.Cmp(nonce) != 0 {
+		return nil, nil, fmt.Errorf("time-stamp response nonce does not match the request")
+	}
+	if err = timestampCoversData(ts, data); err != nil {
+		return nil, nil, err
+	}
+
+	return ts.RawToken, ts, nil
+}
+
+// timestampCoversData checks a time-stamp was issued for the hash of data
+func timestampCoversData(ts *timestamp.Timestamp, data []byte) error {
+	if !ts.HashAlgorithm.Available() {
+		return fmt.Errorf("unsupported time-stamp hash algorithm")
+	}
+
+	h := ts.HashAlgorithm.New()
+	h.Write(data)
+	if !bytes.Equal(h.Sum(nil), ts.HashedMessage) {
+		return fmt.Errorf("time-stamp was not issued for this data")
+	}
+	return nil
+}
+
+// VerifyTimestamp verifies an RFC 3161 time-stamp token issued for data.
+// The time-stamp authority certificate must chain to one of the roots
+// and be valid for time stamping at the time in the token.
+func VerifyTimestamp(token []byte, data []byte, roots *x509.CertPool) (*timestamp.Timestamp, error) {
+	ts, err := timestamp.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if err = timestampCoversData(ts, data); err != nil {
+		return nil, err
+	}
+
+	p7, err := pkcs7.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range p7.Certificates {
+		intermediates.AddCert(c)
+	}
+	err = p7.VerifyWithOpts(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   ts.Time,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ts, nil
+}
+
+// TimestampAuthority signs RFC 3161 time-stamp tokens
+type TimestampAuthority struct {
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+	Signer      crypto.Signer
+	Policy      asn1.ObjectIdentifier
+}
+
+// NewTimestampAuthority issues a time stamping certificate signed by an authority
+func NewTimestampAuthority(name string, parent *x509.Certificate, chain []*x509.Certificate, parentKey crypto.PrivateKey, days int) (*TimestampAuthority, error) {
+	profile, err := lookupProfile("timestamping")
+	if err != nil {
+		return nil, err
+	}
+
+	a := Acert{
+		Subject:         SubjectFields{CommonName: name}.Name(),
+		RootCertificate: *parent,
+		RootPrivateKey:  parentKey,
+		Options: AcertOptions{
+			Days:      days,
+			Backdate:  defaultBackdate,
+			Algorithm: "ecdsa-p256",
+		},
+	}
+	profile.Apply(&a.Options)
+	if err = a.CheckValidity(); err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(a.BuildCertificate(false))
+	if err != nil {
+		return nil, err
+	}
+
+	return &TimestampAuthority{
+		Certificate: cert,
+		Chain:       append([]*x509.Certificate{parent}, chain...),
+		Signer:      a.PrivateKey.(crypto.Signer),
+		Policy:      defaultTimestampPolicy,
+	}, nil
+}
+
+// Respond builds a DER-encoded time-stamp response for a DER-encoded time-stamp request.
+// Requests that cannot be parsed are rejected with an error response.
+func (t *TimestampAuthority) Respond(body []byte) ([]byte, error) {
+	request, err := timestamp.ParseRequest(body)
+	if err != nil {
+		return timestamp.CreateErrorResponse(timestamp.Rejection, timestamp.BadDataFormat)
+	}
+
+	if request.TSAPolicyOID != nil && !request.TSAPolicyOID.Equal(t.Policy) {
+		return timestamp.CreateErrorResponse(timestamp.Rejection, timestamp.UnacceptedPolicy)
+	}
+
+	ts := timestamp.Timestamp{
+		HashAlgorithm:     request.HashAlgorithm,
+		HashedMessage:     request.HashedMessage,
+		Time:              time.Now().UTC(),
+		Accuracy:          time.Second,
+		Nonce:             request.Nonce,
+		Policy:            t.Policy,
+		AddTSACertificate: request.Certificates,
+		Certificates:      t.Chain,
+	}
+
+	return ts.CreateResponseWithOpts(t.Certificate, t.Signer, crypto.SHA256)
+}
+
+// ServeHTTP responds to RFC 3161 time-stamp requests sent over HTTP
+func (t *TimestampAuthority) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Time-stamp requests must be sent using POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := t.Respond(body)
+	if err != nil {
+		log(r.RemoteAddr, "could not create time-stamp response:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log(r.RemoteAddr, "issued time-stamp response")
+	w.Header().Set("Content-Type", timestampReplyType)
+	w.Write(response)
+}
+
+// tsaCommand defines the CLI commands for the time-stamp authority
+func tsaCommand(flags ...string) {
+	// Initialize command
+	cmd, args = newCommand(commandName("tsa"), "Run and use an RFC 3161 time-stamp authority", func(h *command.Command) {
+		h.AddSubcommand("help", "Display this help screen")
+		h.AddSubcommand("serve", "Start a local time-stamp authority server")
+		h.AddSubcommand("request", "Request a time-stamp token for a file")
+		h.AddSubcommand("verify", "Verify a time-stamp token for a file")
+	}, flags...)
+
+	switch getArgument(true) {
+	case "serve":
+		tsaServe(args...)
+	case "request":
+		tsaRequest(args...)
+	case "verify":
+		tsaVerify(args...)
+	default:
+		cmd.Usage()
+	}
+}
+
+// tsaServe starts a time-stamp authority server
+func tsaServe(flags ...string) {
+	var listen, name string
+
+	// Initialize command
+	cmd, args = newCommand(commandName("tsa serve"), "Start a local time-stamp authority server", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&listen, "listen", "127.0.0.1:3161", "Address to listen on")
+			s.StringVar(&parent, "parent", "", "Path to PEM-encoded authority certificate used to issue the time stamping certificate")
+			s.StringVar(&key, "key", "", "Path to PEM-encoded private key of the authority certificate")
+			s.StringVar(&name, "commonName", "acert time-stamp authority", "Common name of the time stamping certificate")
+			s.IntVar(&days, "days", 365, "Number of days the time stamping certificate should be valid for")
+		})
+
+		h.AddExample("Serve time-stamps using a local authority", "-parent local-root.ca.cert.pem -key local-root.ca.key.pem")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	switch getArgument(true) {
+	case "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&parent, "parent")
+		requireFileValue(&key, "key")
+		warnInsecureKeyPermissions(key)
+
+		cert, chain := readSignerChain(parent, "")
+		parentKey := parsePemPrivateKey(key)
+		requireMatchingKey(cert, parentKey, key, parent)
+
+		tsa, err := NewTimestampAuthority(name, cert, chain, parentKey, days)
+		exitOnError(err, "Could not issue time stamping certificate:", err)
+
+		log("Time stamping certificate:", tsa.Certificate.Subject, "(expires:", tsa.Certificate.NotAfter.Format(time.RFC3339)+")")
+		log("Listening on http://" + listen)
+		err = http.ListenAndServe(listen, tsa)
+		exitOnError(err, "Could not start server:", err)
+	}
+}
+
+// tsaRequest requests a time-stamp token for a file
+func tsaRequest(flags ...string) {
+	var url, token string
+
+	// Initialize command
+	cmd, args = newCommand(commandName("tsa request"), "Request a time-stamp token for a file", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&url, "url", "http://127.0.0.1:3161", "URL of the time-stamp authority")
+			s.StringVar(&token, "token", "", "Path to save the time-stamp token to (defaults to FILE.tst)")
+			s.StringVar(&root, "root", "", "Trusted root certificate used to verify the token")
+		})
+
+		h.AddArgument("FILE")
+
+		h.AddExample("Time-stamp a file using a local time-stamp authority", "-root local-root.ca.cert.pem release.tar.gz")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	arg := getArgument(true)
+
+	switch arg {
+	case "", "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&arg, "FILE")
+		data := readFile(arg)
+
+		raw, ts, err := RequestTimestamp(url, data)
+		exitOnError(err, "Could not request time-stamp:", err)
+
+		if root != "" {
+			requireFileValue(&root, "root")
+			_, err = VerifyTimestamp(raw, data, readCertPool(root))
+			exitOnError(err, "Time-stamp could not be verified:", err)
+		}
+
+		saveFile(defaultValue(token, arg+".tst"), raw, 0644, true)
+		log("Time-stamp:", ts.Time.Format(time.RFC3339))
+	}
+}
+
+// tsaVerify verifies a time-stamp token for a file
+func tsaVerify(flags ...string) {
+	var token string
+
+	// Initialize command
+	cmd, args = newCommand(commandName("tsa verify"), "Verify a time-stamp token for a file", func(h *command.Command) {
+		h.AddSection("Options", func(s *command.CommandSection) {
+			s.StringVar(&token, "token", "", "Path to the time-stamp token (defaults to FILE.tst)")
+			s.StringVar(&root, "root", "", "Trusted root certificate")
+		})
+
+		h.AddArgument("FILE")
+
+		h.AddExample("Verify a time-stamped file", "-root local-root.ca.cert.pem release.tar.gz")
+
+		h.AddSubcommand("help", "Display this help screen")
+	}, flags...)
+
+	arg := getArgument(true)
+
+	switch arg {
+	case "", "help":
+		cmd.Usage()
+	default:
+		requireFileValue(&arg, "FILE")
+		requireFileValue(&root, "root")
+		token = defaultValue(token, arg+".tst")
+		requireFileValue(&token, "token")
+
+		ts, err := VerifyTimestamp(readFile(token), readFile(arg), readCertPool(root))
+		exitOnError(err, "Time-stamp could not be verified:", err)
+
+		log("Time-stamp:", ts.Time.Format(time.RFC3339))
+		log("Serial number:", ts.SerialNumber)
+		log("Time-stamp successfully verified")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitorus/timestamp"
+)
+
+// testTimestampAuthority serves a time-stamp authority signed by a new root over HTTP.
+// The root authority is returned for issuing other certificates along with a pool trusting it.
+func testTimestampAuthority(t *testing.T) (*httptest.Server, *Acert, *x509.CertPool) {
+	t.Helper()
+
+	root, file, _ := testAuthority(t, t.TempDir(), "tsa-root")
+	rootCert := testCertificate(t, file)
+	root.RootCertificate = *rootCert
+
+	tsa, err := NewTimestampAuthority("Test TSA", rootCert, nil, root.PrivateKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(tsa)
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCert)
+	return server, root, roots
+}
+
+func TestRequestTimestamp(t *testing.T) {
+	server, _, roots := testTimestampAuthority(t)
+	data := []byte("time-stamped data")
+
+	token, ts, err := RequestTimestamp(server.URL, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(ts.Time); d < -time.Minute || d > time.Minute {
+		t.Errorf("time-stamp time = %v, want about now", ts.Time)
+	}
+
+	verified, err := VerifyTimestamp(token, data, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified.Time.Equal(ts.Time) {
+		t.Errorf("verified time = %v, want %v", verified.Time, ts.Time)
+	}
+
+	if _, err = VerifyTimestamp(token, []byte("tampered data"), roots); err == nil || !strings.Contains(err.Error(), "not issued for this data") {
+		t.Errorf("VerifyTimestamp() = %v, want error for tampered data", err)
+	}
+
+	_, _, other := testTimestampAuthority(t)
+	if _, err = VerifyTimestamp(token, data, other); err == nil {
+		t.Error("VerifyTimestamp() = nil, want error for an untrusted authority")
+	}
+}
+
+func TestRequestTimestampErrors(t *testing.T) {
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	if _, _, err := RequestTimestamp(unavailable.URL, []byte("data")); err == nil || !strings.Contains(err.Error(), "responded with 503") {
+		t.Errorf("RequestTimestamp() = %v, want error for the response status", err)
+	}
+
+	// Responses for other requests are rejected
+	server, _, _ := testTimestampAuthority(t)
+	tsa := server.Config.Handler.(*TimestampAuthority)
+	replay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request, _ := timestamp.CreateRequest(strings.NewReader("other data"), &timestamp.RequestOptions{Certificates: true})
+		response, _ := tsa.Respond(request)
+		w.Header().Set("Content-Type", timestampReplyType)
+		w.Write(response)
+	}))
+	defer replay.Close()
+
+	if _, _, err := RequestTimestamp(replay.URL, []byte("data")); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("RequestTimestamp() = %v, want error for a mismatched nonce", err)
+	}
+}
+
+func TestTimestampAuthorityRespond(t *testing.T) {
+	server, _, _ := testTimestampAuthority(t)
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", response.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	tsa := server.Config.Handler.(*TimestampAuthority)
+	body, err := tsa.Respond([]byte("not a request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = timestamp.ParseResponse(body); err == nil {
+		t.Error("ParseResponse() = nil, want rejection for an invalid request")
+	}
+
+	if !tsa.Certificate.NotBefore.Before(time.Now()) || len(tsa.Certificate.ExtKeyUsage) != 1 || tsa.Certificate.ExtKeyUsage[0] != x509.ExtKeyUsageTimeStamping {
+		t.Errorf("certificate = %v usages %v, want a backdated time stamping certificate", tsa.Certificate.NotBefore, tsa.Certificate.ExtKeyUsage)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+)
+
+// keyUsageNames maps lower case key usage names to key usage bits.
+// Names follow RFC 5280, with common aliases.
+// https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.1.3
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalsignature":  x509.KeyUsageDigitalSignature,
+	"contentcommitment": x509.KeyUsageContentCommitment,
+	"nonrepudiation":    x509.KeyUsageContentCommitment,
+	"keyencipherment":   x509.KeyUsageKeyEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
+	"keycertsign":       x509.KeyUsageCertSign,
+	"certsign":          x509.KeyUsageCertSign,
+	"crlsign":           x509.KeyUsageCRLSign,
+	"encipheronly":      x509.KeyUsageEncipherOnly,
+	"decipheronly":      x509.KeyUsageDecipherOnly,
+}
+
+// extKeyUsageNames maps lower case extended key usage names to extended key usages.
+// https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.1.12
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverauth":      x509.ExtKeyUsageServerAuth,
+	"clientauth":      x509.ExtKeyUsageClientAuth,
+	"codesigning":     x509.ExtKeyUsageCodeSigning,
+	"emailprotection": x509.ExtKeyUsageEmailProtection,
+	"ipsecendsystem":  x509.ExtKeyUsageIPSECEndSystem,
+	"ipsectunnel":     x509.ExtKeyUsageIPSECTunnel,
+	"ipsecuser":       x509.ExtKeyUsageIPSECUser,
+	"timestamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspsigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// extKeyUsageOIDs maps extended key usages to their object identifiers
+var extKeyUsageOIDs = map[x509.ExtKeyUsage]asn1.ObjectIdentifier{
+	x509.ExtKeyUsageAny:             {2, 5, 29, 37, 0},
+	x509.ExtKeyUsageServerAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 1},
+	x509.ExtKeyUsageClientAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 2},
+	x509.ExtKeyUsageCodeSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 3},
+	x509.ExtKeyUsageEmailProtection: {1, 3, 6, 1, 5, 5, 7, 3, 4},
+	x509.ExtKeyUsageIPSECEndSystem:  {1, 3, 6, 1, 5, 5, 7, 3, 5},
+	x509.ExtKeyUsageIPSECTunnel:     {1, 3, 6, 1, 5, 5, 7, 3, 6},
+	x509.ExtKeyUsageIPSECUser:       {1, 3, 6, 1, 5, 5, 7, 3, 7},
+	x509.ExtKeyUsageTimeStamping:    {1, 3, 6, 1, 5, 5, 7, 3, 8},
+	x509.ExtKeyUsageOCSPSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 9},
+}
+
+// Extended key usage extension
+var oidExtensionExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+// ParseKeyUsage parses key usage names into key usage bits
+func ParseKeyUsage(names []string) (x509.KeyUsage, error) {
+	var usage x509.KeyUsage
+
+	for _, name := range names {
+		bit, ok := keyUsageNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return usage, fmt.Errorf("unknown key usage '%s'", name)
+		}
+		usage |= bit
+	}
+
+	return usage, nil
+}
+
+// ParseExtKeyUsage parses extended key usage names.
+// Dotted object identifiers are returned as unknown extended key usages.
+func ParseExtKeyUsage(names []string) ([]x509.ExtKeyUsage, []asn1.ObjectIdentifier, error) {
+	var (
+		usages []x509.ExtKeyUsage
+		oids   []asn1.ObjectIdentifier
+	)
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if usage, ok := extKeyUsageNames[strings.ToLower(name)]; ok {
+			usages = append(usages, usage)
+			continue
+		}
+
+		// Dotted values are object identifiers, anything else is an unknown name
+		oid, err := parseObjectIdentifier(name)
+		if err != nil && strings.Contains(name, ".") {
+			return usages, oids, err
+		} else if err != nil {
+			return usages, oids, fmt.Errorf("unknown extended key usage '%s'", name)
+		}
+		oids = append(oids, oid)
+	}
+
+	return usages, oids, nil
+}
+
+// SetKeyUsage sets the certificate key usages.
+// Configured usages are used as-is, otherwise they are inferred from
+// the type of certificate and its subject alternative names.
+// Key encipherment only applies to RSA keys, so it is removed for other key types.
+// https://datatracker.ietf.org/doc/html/rfc8813#section-3
+func (a *Acert) SetKeyUsage(isCa bool) {
+	a.Certificate.KeyUsage = a.Options.KeyUsage
+	if a.Certificate.KeyUsage == 0 {
+		if isCa {
+			a.Certificate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		} else {
+			a.Certificate.KeyUsage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+		}
+	}
+
+	if !a.isRsaKey() && a.Certificate.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+		if a.Options.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+			log("Warning: keyEncipherment is only used with RSA keys and has been removed")
+		}
+		a.Certificate.KeyUsage &^= x509.KeyUsageKeyEncipherment
+	}
+
+	if len(a.Options.ExtKeyUsage) > 0 || len(a.Options.UnknownExtKeyUsage) > 0 {
+		a.Certificate.ExtKeyUsage = a.Options.ExtKeyUsage
+		a.Certificate.UnknownExtKeyUsage = a.Options.UnknownExtKeyUsage
+		if a.Options.CriticalExtKeyUsage {
+			a.addCriticalExtKeyUsage()
+		}
+		return
+	}
+
+	if !isCa {
+		if len(a.Certificate.IPAddresses) > 0 || len(a.Certificate.DNSNames) > 0 || len(a.Certificate.URIs) > 0 {
+			a.Certificate.ExtKeyUsage = append(a.Certificate.ExtKeyUsage, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth)
+		}
+
+		// Email protection
+		if len(a.Certificate.EmailAddresses) > 0 {
+			a.Certificate.ExtKeyUsage = append(a.Certificate.ExtKeyUsage, x509.ExtKeyUsageEmailProtection)
+		}
+	}
+}
+
+// addCriticalExtKeyUsage adds the extended key usage extension marked as critical.
+// Extra extensions take precedence over the extension built from the template fields.
+func (a *Acert) addCriticalExtKeyUsage() {
+	var oids []asn1.ObjectIdentifier
+	for _, usage := range a.Certificate.ExtKeyUsage {
+		oids = append(oids, extKeyUsageOIDs[usage])
+	}
+	oids = append(oids, a.Certificate.UnknownExtKeyUsage...)
+
+	value, err := asn1.Marshal(oids)
+	if err != nil {
+		panic(err)
+	}
+
+	a.Certificate.ExtraExtensions = append(a.Certificate.ExtraExtensions, pkix.Extension{
+		Id:       oidExtensionExtKeyUsage,
+		Critical: true,
+		Value:    value,
+	})
+}
+
+// isRsaKey reports whether the certificate public key is an RSA key
+func (a *Acert) isRsaKey() bool {
+	_, ok := a.PublicKey.(*rsa.PublicKey)
+	return ok
+}
@@ -11,7 +11,12 @@ func buildAcertCertificate(a *Acert, isCa bool) {
 	requireFileValue(&outputDirectory, "output")
 
 	// Map CLI options
-	configureAcert(a)
+	configureAcert(a, isCa)
+
+	// Refuse to sign with a key that does not belong to the parent certificate
+	if a.RootPrivateKey != nil {
+		requireMatchingKey(&a.RootCertificate, a.RootPrivateKey, key, parent)
+	}
 
 	// Build certificate
 	bytes := a.BuildCertificate(isCa)
@@ -50,6 +55,8 @@ func certificateCommandOptions(h *command.Command, isCa bool, isCsr bool) {
 			certificateBuildFlags(s)
 			if isCa {
 				s.IntVar(&pathLenConstraint, "pathLength", 0, "Maximum number of non-self-issued intermediate certificates that may follow this certificate in a valid certification path (for certificate chaining)")
+			} else {
+				certificateProfileFlags(s)
 			}
 		})
 	}
@@ -58,7 +65,7 @@ func certificateCommandOptions(h *command.Command, isCa bool, isCsr bool) {
 // certificate handles command-line input arguments to create a PKI certificate
 func certificate(flags ...string) {
 	// Initialize command
-	cmd, args = command.NewCommand(commandName("client"), "Create a PKI certificate", func(h *command.Command) {
+	cmd, args = newCommand(commandName("client"), "Create a PKI certificate", func(h *command.Command) {
 		certificateCommandOptions(h, false, false)
 		h.AddSubcommand("help", "Display this help screen")
 	}, flags...)
@@ -67,14 +74,14 @@ func certificate(flags ...string) {
 	case "help":
 		cmd.Usage()
 	default:
-		buildAcertCertificate(&Acert{}, false)
+		buildAcertCertificate(&Acert{}, currentProfile().Authority)
 	}
 }
 
 // certificateAuthority handles command-line input arguments to create a PKI certificate authority.
 func certificateAuthority(flags ...string) {
 	// Initialize command
-	cmd, args = command.NewCommand(commandName("authority"), "Create a PKI certificate authority", func(h *command.Command) {
+	cmd, args = newCommand(commandName("authority"), "Create a PKI certificate authority", func(h *command.Command) {
 		certificateCommandOptions(h, true, false)
 		h.AddSubcommand("help", "Display this help screen")
 	}, flags...)
@@ -91,7 +98,7 @@ func certificateAuthority(flags ...string) {
 // to build a certificate signing request.
 func certificateRequest(flags ...string) {
 	// Initialize command
-	cmd, args = command.NewCommand(commandName("request"), "Create a PKI certificate signing request", func(h *command.Command) {
+	cmd, args = newCommand(commandName("request"), "Create a PKI certificate signing request", func(h *command.Command) {
 		certificateCommandOptions(h, false, true)
 
 		h.AddSubcommand("help", "Display this help screen")
@@ -103,12 +110,13 @@ func certificateRequest(flags ...string) {
 		cmd.Usage()
 	case "sign":
 		// Initialize command
-		cmd, args = command.NewCommand(commandName("request sign"), "Create a PKI certificate from a signing request", func(h *command.Command) {
+		cmd, args = newCommand(commandName("request sign"), "Create a PKI certificate from a signing request", func(h *command.Command) {
 			h.AddSection("General Options", func(s *command.CommandSection) {
 				generalFlags(s)
 			})
 			h.AddSection("Certificate", func(s *command.CommandSection) {
 				certificateBuildFlags(s)
+				certificateProfileFlags(s)
 			})
 
 			h.AddArgument("SIGNING_REQUEST")
@@ -137,7 +145,7 @@ func certificateRequest(flags ...string) {
 
 		// Build certificate signing request
 		a := Acert{}
-		configureAcert(&a)
+		configureAcert(&a, false)
 		request := a.BuildCertificateRequest()
 		savePrivateKeyPem(a.Subject.CommonName, a.PrivateKey)
 		saveCertificateRequestPem(a.Subject.CommonName, request)
@@ -147,11 +155,18 @@ func certificateRequest(flags ...string) {
 // VerifyCertificate validates a certificate root, chain and/or host name.
 func verifyCertificate(flags ...string) {
 	// Initialize command
-	cmd, args = command.NewCommand(commandName("verify"), "Verify a PKI certificate", func(h *command.Command) {
+	cmd, args = newCommand(commandName("verify"), "Verify a PKI certificate", func(h *command.Command) {
 		h.AddSection("Options", func(s *command.CommandSection) {
 			s.StringVar(&hosts, "hosts", "", "Host names to verify")
 			s.StringVar(&root, "root", "", "Trusted root certificate")
 			s.StringVar(&intermediate, "intermediate", "", "Intermediate certificate")
+			s.BoolVar(&checkCrl, "crl", false, "Check that certificates are not revoked using their CRL distribution points")
+		})
+		h.AddSection("Remote Options", func(s *command.CommandSection) {
+			s.StringVar(&connect, "connect", "", "Verify the certificate chain presented by a TLS endpoint (host:port)")
+			s.BoolVar(&saveChain, "save", false, "Save the certificate chain presented by the TLS endpoint")
+			s.StringVar(&outputDirectory, "output", outputDirectory, "Path to directory to save files to")
+			remoteFlags(s)
 		})
 
 		h.AddArgument("CERTIFICATE_FILE")
@@ -159,6 +174,8 @@ func verifyCertificate(flags ...string) {
 		h.AddExample("Verify certificate hosts for a certificate named 'test.com.cert.pem'", "-hosts test.com test.com.cert.pem")
 		h.AddExample("Verify a certificate root", "-root root.ca.cert.pem test.com.cert.pem")
 		h.AddExample("Verify a certificate chain", "-root root.ca.cert.pem -intermediate intermediate.ca.cert.pem test.com.cert.pem")
+		h.AddExample("Verify a certificate has not been revoked", "-root root.ca.cert.pem -crl test.com.cert.pem")
+		h.AddExample("Verify a TLS endpoint", "-root root.ca.cert.pem -connect test.com:443")
 
 		h.AddSubcommand("help", "Display this help screen")
 	}, flags...)
@@ -166,16 +183,32 @@ func verifyCertificate(flags ...string) {
 	// Get first argument
 	arg := getArgument(true)
 
-	switch arg {
-	case "", "help":
+	switch {
+	case arg == "help", arg == "" && connect == "":
 		cmd.Usage()
 	default:
-		// Validate required files are set
-		requireFileValue(&arg, "CERTIFICATE_FILE")
-		requireFileValue(&root, "root")
+		a := Acert{}
+
+		if connect != "" {
+			// Verify the chain presented by the endpoint
+			chain := fetchRemoteChain(connect)
+			a.Certificate = *chain[0]
+			for _, c := range chain[1:] {
+				a.Intermediates = append(a.Intermediates, *c)
+			}
 
-		a := Acert{
-			Certificate: *parsePemCertificate(arg),
+			if hosts == "" {
+				hosts = remoteServerName(connect)
+			}
+			if saveChain {
+				requireFileValue(&outputDirectory, "output")
+				saveRemoteChain(remoteServerName(connect), chain)
+			}
+		} else {
+			// Validate required files are set
+			requireFileValue(&arg, "CERTIFICATE_FILE")
+			requireFileValue(&root, "root")
+			a.Certificate = *parsePemCertificate(arg)
 		}
 
 		// Hosts
@@ -184,17 +217,24 @@ func verifyCertificate(flags ...string) {
 		}
 
 		// Root certificate
+		// System roots are used for remote endpoints when a root is not set
 		if root != "" {
+			requireFileValue(&root, "root")
 			a.RootCertificate = *parsePemCertificate(root)
 		}
 
 		// Intermediate certificate
 		if intermediate != "" {
-			a.RootCertificate = *parsePemCertificate(intermediate)
+			a.IntermediateCertificate = *parsePemCertificate(intermediate)
 		}
 
 		err := a.Verify()
 		exitOnError(err, "Certificate could not be validate.", err)
+
+		if checkCrl {
+			err = CheckRevocation(a.VerifiedChains[0])
+			exitOnError(err, "Certificate revocation could not be verified:", err)
+		}
 		log("Certificate successfully validated")
 	}
 }
@@ -2,7 +2,7 @@ package main
 
 import (
 	"crypto/x509/pkix"
-	"encoding/asn1"
+	"fmt"
 	"os"
 	"strings"
 	"time"
@@ -13,12 +13,32 @@ import (
 var (
 	// General
 	outputDirectory, _ = os.Getwd()
+	keyMode            string
+	keyOwner, keyGroup string
 
 	// Certificate
 	days, pathLenConstraint int
 	now                     = time.Now()
 	trust                   bool
 
+	// Validity period
+	notBefore, notAfter, validity string
+	backdate                      = defaultBackdate.String()
+
+	// Key usage
+	keyUsage, extKeyUsage string
+	profile               string
+
+	// Authority information access and CRL distribution points
+	issuerURL, ocspURL, crlURL string
+
+	// Certificate policies and custom extensions
+	policy, extension string
+
+	// Trust options
+	trustTarget = "system"
+	dryRun      bool
+
 	// Private key
 	bits               int
 	isEcdsa, isEd25519 bool
@@ -32,13 +52,23 @@ var (
 	organization, organizationalUnit                       string
 	commonName, email                                      string
 	san                                                    string
+	subject                                                string
 
 	// Verify options
 	hosts, root, intermediate string
+	connect                   string
+	saveChain                 bool
+	checkCrl                  bool
 )
 
+// Certificates are backdated by default to tolerate clock skew between hosts
+const defaultBackdate = 5 * time.Minute
+
 func generalFlags(h *command.CommandSection) {
 	h.StringVar(&outputDirectory, "output", outputDirectory, "Path to directory to save files to")
+	h.StringVar(&keyMode, "keyMode", "0600", "File mode used when saving private keys (octal)")
+	h.StringVar(&keyOwner, "keyOwner", "", "User name or id that should own saved private keys")
+	h.StringVar(&keyGroup, "keyGroup", "", "Group name or id that should own saved private keys")
 }
 
 // Flags used to build the certificate subject
@@ -52,7 +82,8 @@ func certificateSubjectFlags(h *command.CommandSection) {
 	h.StringVar(&organizationalUnit, "organizationUnit", "", "Organizational Unit Name (eg, section)")
 	h.StringVar(&commonName, "commonName", "", "Certificate common name")
 	h.StringVar(&email, "email", "", "Email Address")
-	h.StringVar(&san, "san", "", "Comma-delimited Subject Alternative Name(s) (DNS, Email, IP, URI)")
+	h.StringVar(&subject, "subject", "", "Full distinguished name in order, overriding the other subject options (eg, /C=US/O=Acme/OU=Eng/OU=Platform/CN=svc or CN=svc,OU=Platform,OU=Eng,O=Acme,C=US)")
+	h.StringVar(&san, "san", "", "Comma-delimited Subject Alternative Name(s) (DNS, Email, IP, URI), optionally prefixed with the type (dns:, ip:, email:, uri:, upn: or rid:)")
 }
 
 // Parses generic cryptography flags
@@ -66,60 +97,139 @@ func certificateKeyFlags(h *command.CommandSection) {
 // Flags to sign a certificate using parent certificate
 func certificateBuildFlags(h *command.CommandSection) {
 	h.IntVar(&days, "days", 90, "Number of days generated certificates should be valid for")
+	certificateValidityFlags(h)
+	h.StringVar(&keyUsage, "keyUsage", "", "Comma-delimited key usages (eg, digitalSignature,keyEncipherment,keyCertSign,cRLSign), inferred when not set")
+	h.StringVar(&extKeyUsage, "extKeyUsage", "", "Comma-delimited extended key usages or object identifiers (eg, serverAuth,clientAuth,codeSigning,timeStamping,OCSPSigning)")
+	certificateDistributionFlags(h)
+	h.StringVar(&policy, "policy", "", "Comma-delimited certificate policy identifiers or names, with an optional CPS URI (eg, domainValidated,1.3.6.1.4.1.99999.1=https://pki.test/cps)")
+	h.StringVar(&extension, "extension", "", "Comma-delimited custom extensions as [critical:]OID=TYPE:VALUE, with types hex, base64, utf8, ia5, printable, bmp, int, bool, oid or null")
 	h.BoolVar(&trust, "trust", false, "Trust generated certificate")
 	h.StringVar(&parent, "parent", "", "Path to PEM-encoded certificate used to sign certificate (authority or intermediate certificate)")
 	h.StringVar(&key, "key", "", "Path to PEM-encoded private key used to sign certificate")
 }
 
-// buildSubject builds a PKIX subject name using input variables.
-func buildSubject() pkix.Name {
+// Flags used to set the certificate validity period.
+// Explicit dates and durations take precedence over the number of days.
+func certificateValidityFlags(h *command.CommandSection) {
+	h.StringVar(&notBefore, "notBefore", "", "Start of the validity period as an RFC 3339 timestamp (eg, 2024-01-01T00:00:00Z)")
+	h.StringVar(&notAfter, "notAfter", "", "End of the validity period as an RFC 3339 timestamp")
+	h.StringVar(&validity, "validity", "", "Validity period as a duration (eg, 36h or 90m), overrides days")
+	h.StringVar(&backdate, "backdate", backdate, "Duration the start of the validity period is moved back to tolerate clock skew")
+}
+
+// Flags used to publish where clients can fetch the issuer certificate and revocation status
+func certificateDistributionFlags(h *command.CommandSection) {
+	h.StringVar(&issuerURL, "issuerURL", "", "Comma-delimited URL(s) of the issuer certificate (authority information access)")
+	h.StringVar(&ocspURL, "ocspURL", "", "Comma-delimited URL(s) of the OCSP responder (authority information access)")
+	h.StringVar(&crlURL, "crlURL", "", "Comma-delimited URL(s) of the certificate revocation list (CRL distribution points)")
+}
+
+// Flags used to select the kind of certificate to issue
+func certificateProfileFlags(h *command.CommandSection) {
+	h.StringVar(&profile, "profile", "", fmt.Sprintf("Certificate profile used to set key usages (%s)", strings.Join(profileNames(), ", ")))
+}
+
+// SubjectFields holds the values used to build a certificate subject.
+type SubjectFields struct {
+	Country            string `json:"country" yaml:"country"`
+	Province           string `json:"province" yaml:"province"`
+	Locality           string `json:"locality" yaml:"locality"`
+	StreetAddress      string `json:"streetAddress" yaml:"streetAddress"`
+	PostalCode         string `json:"postalCode" yaml:"postalCode"`
+	Organization       string `json:"organization" yaml:"organization"`
+	OrganizationalUnit string `json:"organizationUnit" yaml:"organizationUnit"`
+	CommonName         string `json:"commonName" yaml:"commonName"`
+	Email              string `json:"email" yaml:"email"`
+
+	// Full distinguished name, used instead of the other fields
+	DN string `json:"dn" yaml:"dn"`
+}
+
+// Name builds a PKIX subject name from the subject fields.
+func (f SubjectFields) Name() pkix.Name {
 	name := pkix.Name{}
 
-	if country != "" {
-		name.Country = []string{country}
+	if f.Country != "" {
+		name.Country = []string{f.Country}
 	}
-	if province != "" {
-		name.Province = []string{province}
+	if f.Province != "" {
+		name.Province = []string{f.Province}
 	}
-	if locality != "" {
-		name.Locality = []string{locality}
+	if f.Locality != "" {
+		name.Locality = []string{f.Locality}
 	}
-	if streetAddress != "" {
-		name.StreetAddress = []string{streetAddress}
+	if f.StreetAddress != "" {
+		name.StreetAddress = []string{f.StreetAddress}
 	}
-	if postalCode != "" {
-		name.PostalCode = []string{postalCode}
+	if f.PostalCode != "" {
+		name.PostalCode = []string{f.PostalCode}
 	}
-	if organization != "" {
-		name.Organization = []string{organization}
+	if f.Organization != "" {
+		name.Organization = []string{f.Organization}
 	}
-	if organizationalUnit != "" {
-		name.OrganizationalUnit = []string{organizationalUnit}
+	if f.OrganizationalUnit != "" {
+		name.OrganizationalUnit = []string{f.OrganizationalUnit}
 	}
-	if commonName != "" {
-		name.CommonName = commonName
+	if f.CommonName != "" {
+		name.CommonName = f.CommonName
 	}
 
-	// Add email
-	// Thank you: https://stackoverflow.com/a/50394867/4612530
-	if email != "" {
-		// TODO: Read https://www.pkisolutions.com/object-identifiers-oid-in-pki/
-		name.ExtraNames = append(name.ExtraNames, pkix.AttributeTypeAndValue{
-			Type: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1},
-			Value: asn1.RawValue{
-				Tag:   asn1.TagIA5String,
-				Bytes: []byte(email),
-			},
-		})
+	// Email is not a field of PKIX names
+	if f.Email != "" {
+		attribute, err := subjectAttribute("emailAddress", f.Email)
+		if err != nil {
+			panic(err)
+		}
+		name.ExtraNames = append(name.ExtraNames, attribute)
 	}
 
 	return name
 }
 
+// subjectFields returns the subject fields set by input variables.
+func subjectFields() SubjectFields {
+	return SubjectFields{
+		Country:            country,
+		Province:           province,
+		Locality:           locality,
+		StreetAddress:      streetAddress,
+		PostalCode:         postalCode,
+		Organization:       organization,
+		OrganizationalUnit: organizationalUnit,
+		CommonName:         commonName,
+		Email:              email,
+	}
+}
+
+// buildSubject builds a PKIX subject name using input variables.
+func buildSubject() pkix.Name {
+	return subjectFields().Name()
+}
+
+// keyAlgorithm returns the private key algorithm name selected by input variables.
+func keyAlgorithm() string {
+	switch {
+	case isEd25519:
+		return "ed25519"
+	case isEcdsa:
+		return strings.Join([]string{"ecdsa", curve}, "-")
+	default:
+		return "rsa"
+	}
+}
+
+// currentProfile returns the profile selected with the profile flag
+func currentProfile() CertificateProfile {
+	p, err := lookupProfile(profile)
+	exitOnError(err, "Invalid value for 'profile' argument:", err)
+	return p
+}
+
 // configureAcert applies configuration values from the CLI input to the Acert object
-func configureAcert(a *Acert) {
+func configureAcert(a *Acert, isCa bool) {
 	// Add parent key
 	if key != "" || parent != "" {
+		warnInsecureKeyPermissions(key)
 		a.RootPrivateKey = parsePemPrivateKey(key)
 		a.RootCertificate = *parsePemCertificate(parent)
 	}
@@ -127,30 +237,77 @@ func configureAcert(a *Acert) {
 	// If not configuring with a signing request
 	if a.Request.Raw == nil {
 		// Hosts
-		forceStringInput(&san, "Subject Alternative Name(s) (e.g. subdomains) []: ")
+		// Authorities and profiles identifying a subject only require a common name
+		if p := currentProfile(); (isCa || p.SubjectOnly) && san == "" {
+			if subject == "" {
+				forceStringInput(&commonName, "commonName", "Common Name []: ")
+			}
+		} else {
+			forceStringInput(&san, "san", "Subject Alternative Name(s) (e.g. subdomains) []: ")
+		}
 		a.Hosts = splitValue(san, ",")
-		if len(a.Hosts) > 0 && commonName == "" {
-			commonName = a.Hosts[0]
+		err := parseSubjectAlternativeNameValues(a.Hosts)
+		exitOnError(err, "Invalid value for 'san' argument:", err)
+		if len(a.Hosts) > 0 && commonName == "" && subject == "" {
+			commonName = subjectAlternativeNameValue(a.Hosts[0])
 		}
 
 		// Subject
-		a.Subject = buildSubject()
+		if subject != "" {
+			if subjectFields() != (SubjectFields{}) {
+				exit(1, "The 'subject' argument cannot be combined with other subject name arguments")
+			}
+			sequence, err := ParseDistinguishedName(subject)
+			exitOnError(err, "Invalid value for 'subject' argument:", err)
+			a.SetSubjectSequence(sequence)
+		} else {
+			if email != "" {
+				_, err := subjectAttribute("emailAddress", email)
+				exitOnError(err, "Invalid value for 'email' argument:", err)
+			}
+			a.Subject = buildSubject()
+		}
 
 		// Private Key
 		a.Options.Bits = bits
-		var algorithm string
-		switch {
-		case isEd25519:
-			algorithm = "ed25519"
-		case isEcdsa:
-			algorithm = strings.Join([]string{"ecdsa", curve}, "-")
-		default:
-			algorithm = "rsa"
-		}
-		a.Options.Algorithm = algorithm
+		a.Options.Algorithm = keyAlgorithm()
 	}
 
 	// Certificate
 	a.Options.Days = days
+	a.Options.NotBefore = parseTimeValue(notBefore, "notBefore")
+	a.Options.NotAfter = parseTimeValue(notAfter, "notAfter")
+	a.Options.Validity = parseValidityValue(validity, "validity")
+	a.Options.Backdate = parseDurationValue(backdate, "backdate")
 	a.Options.PathLenConstraint = pathLenConstraint
+
+	// Key usage
+	var err error
+	a.Options.KeyUsage, err = ParseKeyUsage(splitValue(keyUsage, ","))
+	exitOnError(err, "Invalid value for 'keyUsage' argument:", err)
+	a.Options.ExtKeyUsage, a.Options.UnknownExtKeyUsage, err = ParseExtKeyUsage(splitValue(extKeyUsage, ","))
+	exitOnError(err, "Invalid value for 'extKeyUsage' argument:", err)
+
+	// Authority information access and CRL distribution points
+	a.Options.IssuingCertificateURL, err = parseURLs(splitValue(issuerURL, ","))
+	exitOnError(err, "Invalid value for 'issuerURL' argument:", err)
+	a.Options.OCSPServer, err = parseURLs(splitValue(ocspURL, ","))
+	exitOnError(err, "Invalid value for 'ocspURL' argument:", err)
+	a.Options.CRLDistributionPoints, err = parseURLs(splitValue(crlURL, ","))
+	exitOnError(err, "Invalid value for 'crlURL' argument:", err)
+
+	// Certificate policies and custom extensions
+	a.Options.Policies, err = ParseCertificatePolicies(splitValue(policy, ","))
+	exitOnError(err, "Invalid value for 'policy' argument:", err)
+	a.Options.Extensions, err = ParseExtensions(splitValue(extension, ","))
+	exitOnError(err, "Invalid value for 'extension' argument:", err)
+	currentProfile().Apply(&a.Options)
+	err = a.CheckExtensions()
+	exitOnError(err, "Invalid value for 'extension' argument:", err)
+
+	if !a.Options.NotBefore.IsZero() && !a.Options.NotAfter.IsZero() && !a.Options.NotAfter.After(a.Options.NotBefore) {
+		exit(1, "The 'notAfter' argument must be later than the 'notBefore' argument")
+	}
+	err = a.CheckValidity()
+	exitOnError(err, "Invalid validity period:", err)
 }
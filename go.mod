@@ -2,4 +2,10 @@ module github.com/lstellway/acert
 
 go 1.16
 
-require github.com/lstellway/go v0.0.0-20210928001238-38d6ddfae647
+require (
+	github.com/digitorus/pkcs7 v0.0.0-20230818184609-3a137a874352
+	github.com/digitorus/timestamp v0.0.0-20231217203849-220c5c2851b7
+	github.com/lstellway/go v0.0.0-20210928001238-38d6ddfae647
+	golang.org/x/term v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -51,26 +51,69 @@ var (
 )
 
 func main() {
-	cmd, args = command.NewCommand(basename, "", func(h *command.Command) {
+	cmd, args = newCommand(basename, "", func(h *command.Command) {
+		h.AddSection("Global Options", func(s *command.CommandSection) {
+			s.BoolVar(&nonInteractive, "non-interactive", false, "Fail instead of prompting for missing input (enabled automatically when stdin is not a terminal)")
+		})
+
 		h.AddSubcommand("authority", "Create a PKI certificate authority")
+		h.AddSubcommand("batch", "Issue PKI certificates described in a manifest file")
 		h.AddSubcommand("client", "Create a PKI certificate")
+		h.AddSubcommand("dev", "Manage a local development certificate authority")
+		h.AddSubcommand("fetch", "Save the certificate chain presented by a TLS endpoint")
+		h.AddSubcommand("match", "Check that private keys, certificates and signing requests share a public key")
+		h.AddSubcommand("repo", "Publish an authority certificate and revocation list")
 		h.AddSubcommand("request", "Create a PKI certificate signing request")
+		h.AddSubcommand("scan", "Scan directories for PKI certificates, keys and signing requests")
+		h.AddSubcommand("serve", "Start a local HTTPS server to test a certificate")
+		h.AddSubcommand("sign", "Create a detached CMS/PKCS #7 signature of a file")
+		h.AddSubcommand("smime", "Sign, verify, encrypt and decrypt S/MIME email messages")
+		h.AddSubcommand("svid", "Issue SPIFFE X.509-SVIDs and trust bundles")
 		h.AddSubcommand("trust", "Trust a PKI certificate")
+		h.AddSubcommand("tsa", "Run and use an RFC 3161 time-stamp authority")
+		h.AddSubcommand("untrust", "Remove a PKI certificate from trust stores")
 		h.AddSubcommand("verify", "Verify a PKI certificate")
+		h.AddSubcommand("verify-signature", "Verify a detached CMS/PKCS #7 signature of a file")
 		h.AddSubcommand("version", "Show Acert version information")
 	}, os.Args[1:]...)
 
 	switch getArgument(true) {
 	case "cert", "certificate", "client":
 		certificate(args...)
+	case "batch":
+		batchCertificates(args...)
 	case "ca", "authority":
 		certificateAuthority(args...)
+	case "dev":
+		devCommand(args...)
+	case "fetch":
+		fetchCertificates(args...)
+	case "match":
+		matchFiles(args...)
+	case "repo":
+		repoCommand(args...)
 	case "csr", "request":
 		certificateRequest(args...)
+	case "scan":
+		scanCertificates(args...)
+	case "serve":
+		serveCertificate(args...)
+	case "sign":
+		signFile(args...)
+	case "smime":
+		smimeCommand(args...)
+	case "svid":
+		svidCommand(args...)
 	case "trust":
 		trustCertificates(args...)
+	case "tsa":
+		tsaCommand(args...)
+	case "untrust":
+		untrustCertificates(args...)
 	case "verify":
 		verifyCertificate(args...)
+	case "verify-signature":
+		verifySignature(args...)
 	case "version":
 		log("acert version:", Version)
 		if ReleaseDate != "" {
@@ -3,20 +3,39 @@ package main
 import (
 	"bufio"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/pem"
+	"flag"
 	"fmt"
+	"net/url"
 	"os"
+	"os/user"
 	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/lstellway/go/command"
+	"golang.org/x/term"
 )
 
 var (
 	basename = "acert"
 	args     []string
 	cmd      command.Command
+
+	// Environment variables used to configure flags are prefixed with this value
+	environmentPrefix = "ACERT_"
+
+	// Fail instead of prompting when required input is missing
+	nonInteractive bool
 )
 
 // Package logger
@@ -57,6 +76,71 @@ func commandName(name string) string {
 	return fmt.Sprintf("%s %s", basename, name)
 }
 
+// NewCommand initializes a command and applies environment variables
+// to any flags that were not explicitly set on the command line.
+// The environment variable name is shown on the usage screen for each flag.
+func newCommand(name string, description string, configure func(h *command.Command), flags ...string) (command.Command, []string) {
+	c, a := command.NewCommand(name, description, func(h *command.Command) {
+		configure(h)
+		h.FlagSet.VisitAll(func(f *flag.Flag) {
+			f.Usage = fmt.Sprintf("%s\nEnvironment: %s", f.Usage, environmentName(f.Name))
+		})
+	}, flags...)
+
+	applyEnvironment(c.FlagSet)
+	return c, a
+}
+
+// EnvironmentName converts a flag name to its environment variable name.
+// For example, "streetAddress" becomes "ACERT_STREET_ADDRESS".
+func environmentName(name string) string {
+	var b strings.Builder
+	b.WriteString(environmentPrefix)
+
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r == '-' || r == '.':
+			b.WriteRune('_')
+		case unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])):
+			b.WriteRune('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+
+	return b.String()
+}
+
+// ApplyEnvironment sets flags from environment variables.
+// Flags set on the command line take precedence over the environment.
+func applyEnvironment(f *flag.FlagSet) {
+	set := map[string]bool{}
+	f.Visit(func(fl *flag.Flag) {
+		set[fl.Name] = true
+	})
+
+	f.VisitAll(func(fl *flag.Flag) {
+		if set[fl.Name] {
+			return
+		}
+
+		name := environmentName(fl.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			err := fl.Value.Set(value)
+			exitOnError(err, fmt.Sprintf("Invalid value for environment variable %s: %s", name, value))
+		}
+	})
+}
+
+// IsInteractive reports whether the user can be prompted for input.
+// Prompts are disabled with the "non-interactive" flag or
+// when stdin is not a terminal (eg, CI jobs and pipes).
+func isInteractive() bool {
+	return !nonInteractive && term.IsTerminal(int(os.Stdin.Fd()))
+}
+
 // Builds path in output directory
 func getOutputPath(name string) string {
 	return path.Join(outputDirectory, name)
@@ -83,15 +167,228 @@ func requireFileValue(value *string, name string) {
 // and specified permissions.
 // There is an option to determine whether or not to report success.
 func saveFile(name string, data []byte, permissions os.FileMode, report bool) {
+	saveFileOwner(name, data, permissions, -1, -1, report)
+}
+
+// SaveFileOwner saves a file to the filesystem with a specified name,
+// permissions and owner. A uid or gid of -1 leaves the value unchanged.
+// There is an option to determine whether or not to report success.
+func saveFileOwner(name string, data []byte, permissions os.FileMode, uid int, gid int, report bool) {
 	// Write to filesystem
-	err := os.WriteFile(name, data, permissions)
-	exitOnError(err, "Could not save file:", name)
+	err := writeFileAtomic(name, data, permissions, uid, gid)
+	exitOnError(err, "Could not save file:", name, err)
 
 	if report {
 		log("Saved file:", name)
 	}
 }
 
+// WriteFileAtomic writes data to a temporary file in the destination
+// directory and renames it into place once it is complete.
+// Permissions and ownership are applied before the rename, so the file
+// is never visible with looser permissions or partial contents.
+func writeFileAtomic(name string, data []byte, permissions os.FileMode, uid int, gid int) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	// Clean up the temporary file if anything fails before the rename
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = tmp.Chmod(permissions); err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if err = tmp.Chown(uid, gid); err != nil {
+			return err
+		}
+	}
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+
+	committed = true
+	return nil
+}
+
+// LookupUserId resolves a user name or numeric id to a uid.
+// An empty value returns -1, which leaves ownership unchanged.
+func lookupUserId(name string) int {
+	if name == "" {
+		return -1
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id
+	}
+
+	u, err := user.Lookup(name)
+	exitOnError(err, "Could not find user:", name)
+	id, err := strconv.Atoi(u.Uid)
+	exitOnError(err, "User does not have a numeric id:", name)
+	return id
+}
+
+// LookupGroupId resolves a group name or numeric id to a gid.
+// An empty value returns -1, which leaves ownership unchanged.
+func lookupGroupId(name string) int {
+	if name == "" {
+		return -1
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id
+	}
+
+	g, err := user.LookupGroup(name)
+	exitOnError(err, "Could not find group:", name)
+	id, err := strconv.Atoi(g.Gid)
+	exitOnError(err, "Group does not have a numeric id:", name)
+	return id
+}
+
+// ParseFileMode parses an octal file mode argument (eg, 0600)
+func parseFileMode(value string, name string) os.FileMode {
+	mode, err := parseFileModeValue(value)
+	exitOnError(err, fmt.Sprintf("Invalid file mode for '%s' argument: %s", name, value))
+	return mode
+}
+
+// ParseFileModeValue parses an octal file mode string.
+// Only permission bits are accepted.
+func parseFileModeValue(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if os.FileMode(mode) != os.FileMode(mode).Perm() {
+		return 0, fmt.Errorf("file mode %s is not a permission mode", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// ParseObjectIdentifier parses a dotted object identifier (eg, 1.3.6.1.5.5.7.3.1)
+// Identifiers that cannot be DER encoded (X.690 8.19.4) are rejected.
+func parseObjectIdentifier(value string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(strings.TrimSpace(value), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid object identifier '%s': at least two arcs are required", value)
+	}
+
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || strings.HasPrefix(part, "+") {
+			return nil, fmt.Errorf("invalid object identifier '%s': arcs must be non-negative integers", value)
+		}
+		oid[i] = n
+	}
+
+	// The first two arcs are encoded together as 40 * first + second
+	if oid[0] > 2 {
+		return nil, fmt.Errorf("invalid object identifier '%s': the first arc must be 0, 1 or 2", value)
+	}
+	if oid[0] < 2 && oid[1] >= 40 {
+		return nil, fmt.Errorf("invalid object identifier '%s': the second arc must be less than 40 when the first arc is 0 or 1", value)
+	}
+
+	return oid, nil
+}
+
+// ParseTimeValue parses an RFC 3339 timestamp argument.
+// An empty value returns the zero time.
+func parseTimeValue(value string, name string) time.Time {
+	if strings.TrimSpace(value) == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	exitOnError(err, fmt.Sprintf("Invalid RFC 3339 timestamp for '%s' argument: %s", name, value))
+	return t
+}
+
+// ParseDurationValue parses a Go duration argument (eg, 36h or 90m).
+// An empty value returns a zero duration.
+func parseDurationValue(value string, name string) time.Duration {
+	d, err := parseDuration(value)
+	exitOnError(err, fmt.Sprintf("Invalid duration for '%s' argument: %s", name, value))
+	return d
+}
+
+// ParseValidityValue parses a certificate validity duration argument.
+// An empty value returns a zero duration, so the number of days is used.
+func parseValidityValue(value string, name string) time.Duration {
+	d, err := parseValidity(value)
+	exitOnError(err, fmt.Sprintf("Invalid duration for '%s' argument: %s", name, value))
+	return d
+}
+
+// ParseDuration parses a non-negative Go duration (eg, 36h or 90m).
+// An empty value returns a zero duration.
+func parseDuration(value string) (time.Duration, error) {
+	if strings.TrimSpace(value) == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err == nil && d < 0 {
+		err = fmt.Errorf("negative duration")
+	}
+	return d, err
+}
+
+// ParseValidity parses a certificate validity duration.
+// Validity periods that are set must be positive.
+func parseValidity(value string) (time.Duration, error) {
+	d, err := parseDuration(value)
+	if err == nil && d == 0 && strings.TrimSpace(value) != "" {
+		err = fmt.Errorf("zero duration")
+	}
+	return d, err
+}
+
+// ParseURLs validates absolute URLs (eg, http://pki.test/ca.crt)
+func parseURLs(values []string) ([]string, error) {
+	for _, value := range values {
+		u, err := url.Parse(value)
+		if err != nil || !u.IsAbs() {
+			return nil, fmt.Errorf("invalid URL '%s', an absolute URL is required", value)
+		}
+	}
+	return values, nil
+}
+
+// WarnInsecureKeyPermissions logs a warning when a private key file
+// can be read by the group or other users.
+// File permission bits are not meaningful on Windows, so the check is skipped.
+func warnInsecureKeyPermissions(file string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return
+	}
+
+	if mode := info.Mode().Perm(); mode&0044 != 0 {
+		log(fmt.Sprintf("Warning: private key '%s' is readable by other users (%#o)", file, mode))
+		log(fmt.Sprintf("Consider restricting access with: chmod 600 %s", file))
+	}
+}
+
 // SplitValue splits a string value by a delimiter and returns a string array with the values.
 // Values are trimmed of whitespace and empty values are ignored.
 func splitValue(value string, delimiter string) []string {
@@ -117,11 +414,17 @@ func promptForInput(message string) (string, error) {
 
 // ForceStringInput will repeatedly prompt a user for input
 // until a non-empty string value is inputted.
-func forceStringInput(variable *string, message string) string {
+// When running non-interactively, the program exits if the value is empty.
+func forceStringInput(variable *string, name string, message string) string {
 	val := ""
 
+	if strings.TrimSpace(*variable) == "" && !isInteractive() {
+		exit(1, fmt.Sprintf("Missing required value for '%s' argument: set the -%s flag or the %s environment variable", name, name, environmentName(name)))
+	}
+
 	for strings.TrimSpace(*variable) == "" {
-		val, _ := promptForInput(message)
+		val, err := promptForInput(message)
+		exitOnError(err, "\nCould not read input:", err)
 		*variable = strings.TrimSpace(val)
 	}
 
@@ -135,6 +438,15 @@ func readFile(file string) []byte {
 	return data
 }
 
+// ReadFileIfExists returns the byte contents of a file,
+// or nil if the file does not exist.
+func readFileIfExists(file string) []byte {
+	if !fileExists(file) {
+		return nil
+	}
+	return readFile(file)
+}
+
 // PemEncode PEM-encodes an input byte array of a specified type.
 func pemEncode(name string, data []byte) []byte {
 	return pem.EncodeToMemory(&pem.Block{
@@ -185,6 +497,40 @@ func parsePemCertificate(file string) *x509.Certificate {
 	return cert
 }
 
+// ReadPemCertificates reads every certificate in a PEM-encoded file.
+// Unlike parsePemCertificate, errors are returned instead of exiting,
+// which allows callers to skip files that cannot be parsed.
+func readPemCertificates(file string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return certs, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in file: %s", file)
+	}
+
+	return certs, nil
+}
+
 // ParsePemCertificateRequest reads a specified PEM-encoded
 // certificate request file and parses it into a x509.CertificateRequest object
 func parsePemCertificateRequest(file string) *x509.CertificateRequest {
@@ -203,6 +549,65 @@ func parsePemPrivateKey(file string) crypto.PrivateKey {
 	return cert
 }
 
+// ParsePrivateKeyBlock parses a PEM block containing a PKCS #8, PKCS #1 (RSA) or SEC 1 (EC) private key
+func parsePrivateKeyBlock(block *pem.Block) (crypto.PrivateKey, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+}
+
+// PublicKey returns the public key of a private key
+func publicKey(privateKey crypto.PrivateKey) crypto.PublicKey {
+	if signer, ok := privateKey.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return nil
+}
+
+// PublicKeysMatch reports whether two public keys are equal
+func publicKeysMatch(a crypto.PublicKey, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && b != nil && key.Equal(b)
+}
+
+// RequireMatchingKey exits if a private key does not correspond to the public key of a certificate.
+// Signing with a mismatched key would produce a certificate that cannot be verified.
+func requireMatchingKey(cert *x509.Certificate, privateKey crypto.PrivateKey, keyFile string, certFile string) {
+	if !publicKeysMatch(publicKey(privateKey), cert.PublicKey) {
+		exit(1, fmt.Sprintf("Private key '%s' does not match the public key of certificate '%s'", keyFile, certFile))
+	}
+}
+
+// KeyAlgorithmName describes the algorithm of a private or public key
+func keyAlgorithmName(key interface{}) string {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return fmt.Sprintf("RSA-%d", k.N.BitLen())
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", k.N.BitLen())
+	case *ecdsa.PrivateKey:
+		return "ECDSA-" + k.Curve.Params().Name
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + k.Curve.Params().Name
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("%T", key)
+	}
+}
+
+// CertificateFingerprint returns the hex-encoded hash of a DER-encoded certificate
+func certificateFingerprint(cert *x509.Certificate, hash crypto.Hash) string {
+	h := hash.New()
+	h.Write(cert.Raw)
+	return fmt.Sprintf("%X", h.Sum(nil))
+}
+
 // Get private key PKCS #8
 func privateKeyPkcs(privateKey crypto.PrivateKey) []byte {
 	key, err := x509.MarshalPKCS8PrivateKey(privateKey)
@@ -232,8 +637,7 @@ func saveCertificatePem(name string, bytes []byte, trust bool) {
 
 	// Trust certificate
 	if trust {
-		log("Sudo permissions are required to trust certificates")
-		Trust(getOutputPath(name + ".cert.pem"))
+		TrustTargets(getOutputPath(name + ".cert.pem"))
 	}
 }
 
@@ -244,7 +648,9 @@ func saveCertificateRequestPem(name string, request []byte) {
 }
 
 // savePrivateKeyFile saves PEM-encoded private key file
+// using the configured key file mode and ownership.
 func savePrivateKeyPem(name string, privateKey crypto.PrivateKey) {
 	pem := pemEncode("PRIVATE KEY", privateKeyPkcs(privateKey))
-	savePemFile(name+".key.pem", pem)
+	mode := parseFileMode(keyMode, "keyMode")
+	saveFileOwner(getOutputPath(name+".key.pem"), pem, mode, lookupUserId(keyOwner), lookupGroupId(keyGroup), true)
 }
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvironmentName(t *testing.T) {
+	tests := map[string]string{
+		"san":              "ACERT_SAN",
+		"streetAddress":    "ACERT_STREET_ADDRESS",
+		"non-interactive":  "ACERT_NON_INTERACTIVE",
+		"organizationUnit": "ACERT_ORGANIZATION_UNIT",
+		"issuerURL":        "ACERT_ISSUER_URL",
+	}
+
+	for name, want := range tests {
+		if got := environmentName(name); got != want {
+			t.Errorf("environmentName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestApplyEnvironment(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"default", nil, nil, "default"},
+		{"environment", nil, map[string]string{"ACERT_COMMON_NAME": "env"}, "env"},
+		{"flag", []string{"-commonName", "flag"}, nil, "flag"},
+		{"flag over environment", []string{"-commonName", "flag"}, map[string]string{"ACERT_COMMON_NAME": "env"}, "flag"},
+		{"empty environment", nil, map[string]string{"ACERT_COMMON_NAME": ""}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			var value string
+			f := flag.NewFlagSet("test", flag.ContinueOnError)
+			f.StringVar(&value, "commonName", "default", "")
+			if err := f.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			applyEnvironment(f)
+			if value != tt.want {
+				t.Errorf("value = %q, want %q", value, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvironmentBool(t *testing.T) {
+	t.Setenv("ACERT_ECDSA", "true")
+
+	var ecdsa, ed25519 bool
+	f := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.BoolVar(&ecdsa, "ecdsa", false, "")
+	f.BoolVar(&ed25519, "ed25519", false, "")
+	if err := f.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	applyEnvironment(f)
+	if !ecdsa || ed25519 {
+		t.Errorf("ecdsa = %v, ed25519 = %v, want true, false", ecdsa, ed25519)
+	}
+}
+
+func TestParseObjectIdentifier(t *testing.T) {
+	tests := []struct {
+		value string
+		want  asn1.ObjectIdentifier
+	}{
+		{"1.3.6.1.5.5.7.3.1", asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}},
+		{" 2.5.4.3 ", asn1.ObjectIdentifier{2, 5, 4, 3}},
+		{"0.39", asn1.ObjectIdentifier{0, 39}},
+		{"2.999.1", asn1.ObjectIdentifier{2, 999, 1}},
+		{"", nil},
+		{"1", nil},
+		{"1..3", nil},
+		{"1.3.", nil},
+		{"1.-3.6", nil},
+		{"1.+3.6", nil},
+		{"1.3.a", nil},
+		{"3.1", nil},
+		{"5.5", nil},
+		{"0.40", nil},
+		{"1.40.1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			oid, err := parseObjectIdentifier(tt.value)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("parseObjectIdentifier(%q) = %v, want error", tt.value, oid)
+				}
+				return
+			}
+			if err != nil || !oid.Equal(tt.want) {
+				t.Errorf("parseObjectIdentifier(%q) = %v, %v, want %v", tt.value, oid, err, tt.want)
+			}
+			if _, err := asn1.Marshal(oid); err != nil {
+				t.Errorf("asn1.Marshal(%v) = %v", oid, err)
+			}
+		})
+	}
+}
+
+func TestParseFileModeValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  os.FileMode
+		valid bool
+	}{
+		{"0600", 0600, true},
+		{"600", 0600, true},
+		{" 0640 ", 0640, true},
+		{"0", 0, true},
+		{"0777", 0777, true},
+		{"", 0, false},
+		{"rw-------", 0, false},
+		{"0800", 0, false},
+		{"-600", 0, false},
+		{"+600", 0, false},
+		{"01000", 0, false},
+		{"4755", 0, false},
+	}
+
+	for _, tt := range tests {
+		mode, err := parseFileModeValue(tt.value)
+		if (err == nil) != tt.valid || mode != tt.want {
+			t.Errorf("parseFileModeValue(%q) = %o, %v, want %o, valid %v", tt.value, mode, err, tt.want, tt.valid)
+		}
+	}
+}
+
+// testTempFiles returns the temporary files left in a directory by writeFileAtomic.
+func testTempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file.pem")
+
+	for _, mode := range []os.FileMode{0644, 0600, 0640} {
+		data := []byte(mode.String())
+		if err := writeFileAtomic(name, data, mode, -1, -1); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("mode = %o, want %o", info.Mode().Perm(), mode)
+		}
+		if saved, _ := os.ReadFile(name); string(saved) != string(data) {
+			t.Errorf("data = %q, want %q", saved, data)
+		}
+	}
+
+	if files := testTempFiles(t, dir); len(files) > 0 {
+		t.Errorf("temporary files were left behind: %v", files)
+	}
+}
+
+func TestWriteFileAtomicFailure(t *testing.T) {
+	dir := t.TempDir()
+
+	// Renaming over a non-empty directory fails after the data is written
+	name := filepath.Join(dir, "directory")
+	if err := os.MkdirAll(filepath.Join(name, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(name, []byte("data"), 0600, -1, -1); err == nil {
+		t.Fatal("writeFileAtomic() succeeded over a directory")
+	}
+	if files := testTempFiles(t, dir); len(files) > 0 {
+		t.Errorf("temporary files were left behind: %v", files)
+	}
+
+	// Missing directories fail without creating anything
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "file.pem"), []byte("data"), 0600, -1, -1); err == nil {
+		t.Fatal("writeFileAtomic() succeeded in a missing directory")
+	}
+}
+
+func TestSavePrivateKeyPem(t *testing.T) {
+	dir := t.TempDir()
+	previous := []string{outputDirectory, keyMode, keyOwner, keyGroup}
+	t.Cleanup(func() {
+		outputDirectory, keyMode, keyOwner, keyGroup = previous[0], previous[1], previous[2], previous[3]
+	})
+	outputDirectory, keyOwner, keyGroup = dir, "", ""
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		keyMode string
+		want    os.FileMode
+	}{
+		{"0600", 0600},
+		{"0640", 0640},
+		{"400", 0400},
+	} {
+		keyMode = tt.keyMode
+		savePrivateKeyPem("mode-"+tt.keyMode, key)
+
+		name := filepath.Join(dir, "mode-"+tt.keyMode+".key.pem")
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != tt.want {
+			t.Errorf("keyMode %s: mode = %o, want %o", tt.keyMode, info.Mode().Perm(), tt.want)
+		}
+		if _, ok := parsePemPrivateKey(name).(*ecdsa.PrivateKey); !ok {
+			t.Errorf("keyMode %s: saved key is not an ECDSA key", tt.keyMode)
+		}
+	}
+}